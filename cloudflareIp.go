@@ -2,13 +2,7 @@ package traefik_real_ip
 
 import (
 	"context"
-	"net"
-	"sync"
-)
-
-var (
-	cloudFlareIPsInstance []*net.IPNet
-	cloudFlareIPsOnce     sync.Once
+	"net/netip"
 )
 
 const (
@@ -17,19 +11,17 @@ const (
 )
 
 var cloudflareProvider = remoteIPProvider{
-	name:  "Cloudflare",
-	urls:  []string{cloudflareIPv4URL, cloudflareIPv6URL},
-	once:  &cloudFlareIPsOnce,
-	cache: &cloudFlareIPsInstance,
+	name: "Cloudflare",
+	urls: []string{cloudflareIPv4URL, cloudflareIPv6URL},
 }
 
-func (resolver *IPResolver) getCloudFlareIPs(ctx context.Context) []*net.IPNet {
+func (resolver *IPResolver) getCloudFlareIPs(ctx context.Context) []netip.Prefix {
 	return resolver.getProviderIPs(ctx, cloudflareProvider)
 }
 
 func (resolver *IPResolver) getCloudFlareIPFromURL(
 	ctx context.Context,
 	url string,
-) ([]*net.IPNet, error) {
+) ([]netip.Prefix, error) {
 	return resolver.getProviderIPsFromURL(ctx, cloudflareProvider.name, url)
 }
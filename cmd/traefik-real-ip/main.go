@@ -0,0 +1,230 @@
+// Command traefik-real-ip converts trusted proxy lists of other servers to the
+// format of the traefik-real-ip middleware, and exports the ranges trusted by
+// a middleware configuration for other servers.
+//
+// Usage:
+//
+//	traefik-real-ip convert -from nginx [-to plain|yaml|traefik|nginx|ipset|json] [file ...]
+//	traefik-real-ip export [-config plugin.json] [-format traefik|nginx|ipset|json] [-o file]
+//
+// Without files, convert reads the list from standard input. The export
+// configuration is the JSON form of the middleware options.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+
+	traefikrealip "github.com/zekihan/traefik-real-ip"
+)
+
+var (
+	errUsage = errors.New(
+		"usage: traefik-real-ip convert -from <format> [-to <format>] [file ...]\n" +
+			"       traefik-real-ip export [-config <file>] [-format <format>] [-o <file>]",
+	)
+	errOutputFormat      = errors.New("unknown output format")
+	errUnexpectedHandler = errors.New("unexpected middleware handler")
+)
+
+func main() {
+	// The middleware logs to standard output, which carries the results here.
+	traefikrealip.SetLogOutput(os.Stderr)
+
+	err := run(os.Args[1:], os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "convert":
+		return convert(args[1:], stdin, stdout)
+	case "export":
+		return export(args[1:], stdout)
+	default:
+		return errUsage
+	}
+}
+
+func convert(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	from := flags.String("from", traefikrealip.ListFormatPlain,
+		"input format: plain, nginx, apache, haproxy or ipset")
+	to := flags.String("to", "plain", "output format: plain, yaml, traefik, nginx, ipset or json")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+
+	inputs, err := readInputs(flags.Args(), stdin)
+	if err != nil {
+		return err
+	}
+
+	prefixes := make([]netip.Prefix, 0)
+
+	for _, input := range inputs {
+		parsed, err := traefikrealip.ParseRangeList(*from, input.body)
+		if err != nil {
+			return fmt.Errorf("%s: %w", input.name, err)
+		}
+
+		prefixes = append(prefixes, parsed...)
+	}
+
+	return writePrefixes(stdout, *to, prefixes)
+}
+
+type input struct {
+	name string
+	body string
+}
+
+func readInputs(paths []string, stdin io.Reader) ([]input, error) {
+	if len(paths) == 0 {
+		body, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("error reading standard input: %w", err)
+		}
+
+		return []input{{name: "stdin", body: string(body)}}, nil
+	}
+
+	inputs := make([]input, 0, len(paths))
+
+	for _, path := range paths {
+		body, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", path, err)
+		}
+
+		inputs = append(inputs, input{name: path, body: string(body)})
+	}
+
+	return inputs, nil
+}
+
+func writePrefixes(out io.Writer, format string, prefixes []netip.Prefix) error {
+	var builder strings.Builder
+
+	switch format {
+	case traefikrealip.ExportFormatTraefik, traefikrealip.ExportFormatNginx,
+		traefikrealip.ExportFormatIPSet, traefikrealip.ExportFormatJSON:
+		ranges := make([]traefikrealip.TrustedRange, 0, len(prefixes))
+		for _, prefix := range prefixes {
+			ranges = append(ranges, traefikrealip.TrustedRange{Prefix: prefix})
+		}
+
+		return traefikrealip.WriteTrustedRanges(out, format, ranges)
+	case "plain":
+		for _, prefix := range prefixes {
+			builder.WriteString(prefix.String() + "\n")
+		}
+	case "yaml":
+		builder.WriteString("trustedIPs:\n")
+
+		for _, prefix := range prefixes {
+			fmt.Fprintf(&builder, "  - %q\n", prefix.String())
+		}
+	default:
+		return fmt.Errorf("%w: %q", errOutputFormat, format)
+	}
+
+	_, err := io.WriteString(out, builder.String())
+	if err != nil {
+		return fmt.Errorf("error writing output: %w", err)
+	}
+
+	return nil
+}
+
+func export(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	configPath := flags.String("config", "", "middleware configuration as JSON")
+	format := flags.String("format", traefikrealip.ExportFormatTraefik,
+		"output format: traefik, nginx, ipset or json")
+	output := flags.String("o", "", "output file instead of standard output")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+
+	if flags.NArg() > 0 {
+		return errUsage
+	}
+
+	config := traefikrealip.CreateConfig()
+
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", *configPath, err)
+		}
+
+		err = json.Unmarshal(data, config)
+		if err != nil {
+			return fmt.Errorf("error parsing %s: %w", *configPath, err)
+		}
+	}
+
+	// Load everything once: no background loading, reloading or writer.
+	config.NonBlockingStartup = false
+	config.FilePollInterval = "0s"
+	config.Export = traefikrealip.ExportConfig{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handler, err := traefikrealip.New(ctx, http.NotFoundHandler(), config, "export")
+	if err != nil {
+		return fmt.Errorf("error loading trusted ranges: %w", err)
+	}
+
+	resolver, ok := handler.(*traefikrealip.IPResolver)
+	if !ok {
+		return fmt.Errorf("%w: %T", errUnexpectedHandler, handler)
+	}
+
+	if *output == "" {
+		return traefikrealip.WriteTrustedRanges(stdout, *format, resolver.TrustedRanges())
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", *output, err)
+	}
+
+	err = traefikrealip.WriteTrustedRanges(file, *format, resolver.TrustedRanges())
+	closeErr := file.Close()
+
+	if err != nil {
+		return err
+	}
+
+	if closeErr != nil {
+		return fmt.Errorf("error writing %s: %w", *output, closeErr)
+	}
+
+	return nil
+}
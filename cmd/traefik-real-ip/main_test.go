@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	dir := t.TempDir()
+	nginx := filepath.Join(dir, "cloudflare.conf")
+
+	content := "set_real_ip_from 173.245.48.0/20;\nset_real_ip_from 2400:cb00::/32;\n"
+
+	err := os.WriteFile(nginx, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		args  []string
+		stdin string
+		want  string
+	}{
+		{
+			name: "file to plain",
+			args: []string{"convert", "-from", "nginx", nginx},
+			want: "173.245.48.0/20\n2400:cb00::/32\n",
+		},
+		{
+			name:  "stdin to yaml",
+			args:  []string{"convert", "-from", "haproxy", "-to", "yaml"},
+			stdin: "192.0.2.1 cdn\n10.0.0.0/8\n",
+			want:  "trustedIPs:\n  - \"192.0.2.1/32\"\n  - \"10.0.0.0/8\"\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+
+			err := run(tt.args, strings.NewReader(tt.stdin), &out)
+			if err != nil {
+				t.Fatalf("run: %v", err)
+			}
+
+			if out.String() != tt.want {
+				t.Errorf("output = %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestConvert_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr error
+	}{
+		{name: "no command", args: nil, wantErr: errUsage},
+		{name: "unknown command", args: []string{"serve"}, wantErr: errUsage},
+		{name: "unknown flag", args: []string{"convert", "-format", "nginx"}, wantErr: errUsage},
+		{name: "output format", args: []string{"convert", "-to", "toml"}, wantErr: errOutputFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := run(tt.args, strings.NewReader(""), &bytes.Buffer{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	stdin := strings.NewReader("set_real_ip_from x;")
+
+	err := run([]string{"convert", "-from", "nginx"}, stdin, &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "stdin") {
+		t.Errorf("expected an error naming the input, got %v", err)
+	}
+}
+
+func TestConvert_ExportFormat(t *testing.T) {
+	var out bytes.Buffer
+
+	err := run([]string{"convert", "-from", "ipset", "-to", "nginx"},
+		strings.NewReader("add trusted 10.0.0.0/8\n"), &out)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if out.String() != "set_real_ip_from 10.0.0.0/8;\n" {
+		t.Errorf("unexpected output %q", out.String())
+	}
+}
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "plugin.json")
+	output := filepath.Join(dir, "trusted.json")
+
+	err := os.WriteFile(config, []byte(`{
+		"thrustLocal": false,
+		"thrustCloudFlare": false,
+		"trustedIPs": ["192.0.2.0/24", "10.0.0.0/8"],
+		"export": {"path": "/nonexistent/ignored", "format": "json"}
+	}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+
+	err = run([]string{"export", "-config", config, "-format", "nginx"}, nil, &out)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	want := "set_real_ip_from 10.0.0.0/8; # custom\nset_real_ip_from 192.0.2.0/24; # custom\n"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+
+	err = run([]string{"export", "-config", config, "-format", "json", "-o", output}, nil, &out)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil || !strings.Contains(string(data), `"cidr": "192.0.2.0/24"`) {
+		t.Errorf("expected a JSON export, got %q (%v)", data, err)
+	}
+
+	err = run([]string{"export", "-config", filepath.Join(dir, "missing.json")}, nil, &out)
+	if err == nil {
+		t.Error("expected an error for a missing configuration")
+	}
+}
@@ -6,6 +6,7 @@ const (
 	XRealIP        = "X-Real-IP"
 	XForwardedFor  = "X-Forwarded-For"
 	XIsTrusted     = "X-Is-Trusted"
+	XTrustedSource = "X-Trusted-Source"
 )
 
 const (
@@ -15,6 +16,61 @@ const (
 	LogLevelError = "error"
 )
 
+const (
+	NotReadyPolicyStatic  = "static"
+	NotReadyPolicyReject  = "reject"
+	NotReadyPolicyPending = "pending"
+)
+
+const (
+	PrivateClientPolicyAccept = "accept"
+	PrivateClientPolicySkip   = "skip"
+	PrivateClientPolicySource = "source"
+	PrivateClientPolicyReject = "reject"
+)
+
+const (
+	InvalidHeaderPolicyReject = "reject"
+	InvalidHeaderPolicyIgnore = "ignore"
+	InvalidHeaderPolicySource = "source"
+)
+
+const TrustedPending = "pending"
+
+const (
+	ListFormatPlain   = "plain"
+	ListFormatNginx   = "nginx"
+	ListFormatApache  = "apache"
+	ListFormatHAProxy = "haproxy"
+	ListFormatIPSet   = "ipset"
+)
+
+const (
+	ExportFormatTraefik = "traefik"
+	ExportFormatNginx   = "nginx"
+	ExportFormatIPSet   = "ipset"
+	ExportFormatJSON    = "json"
+)
+
+// Categories of non-public ranges, see nonPublicCategories.
+const (
+	NonPublicUnspecified         = "unspecified"
+	NonPublicThisNetwork         = "thisNetwork"
+	NonPublicLoopback            = "loopback"
+	NonPublicPrivate             = "private"
+	NonPublicUniqueLocal         = "uniqueLocal"
+	NonPublicSharedAddressSpace  = "sharedAddressSpace"
+	NonPublicLinkLocal           = "linkLocal"
+	NonPublicProtocolAssignments = "protocolAssignments"
+	NonPublicDocumentation       = "documentation"
+	NonPublicBenchmarking        = "benchmarking"
+	NonPublicMulticast           = "multicast"
+	NonPublicReserved            = "reserved"
+	NonPublicDiscard             = "discard"
+	NonPublicTranslation         = "translation"
+	NonPublicSegmentRouting      = "segmentRouting"
+)
+
 type ContextKey string
 
 const RetryCountKey ContextKey = "retryCount"
@@ -0,0 +1,345 @@
+package traefik_real_ip
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrDNSQuery    = errors.New("DNS query failed")
+	ErrDNSResponse = errors.New("malformed DNS response")
+)
+
+const (
+	dnsTypeA          = 1
+	dnsTypeAAAA       = 28
+	dnsClassIN        = 1
+	dnsHeaderSize     = 12
+	dnsBufferSize     = 4096
+	dnsFlagResponse   = 0x8000
+	dnsFlagTruncated  = 0x0200
+	dnsFlagRecursion  = 0x0100
+	dnsRcodeMask      = 0x000f
+	dnsRcodeNameError = 3
+	defaultDNSPort    = "53"
+	resolvConfPath    = "/etc/resolv.conf"
+)
+
+// dnsAnswer holds the addresses of a lookup and the lowest TTL among their
+// records.
+type dnsAnswer struct {
+	addrs []netip.Addr
+	ttl   time.Duration
+}
+
+// dnsClient is a minimal stub resolver sending A and AAAA queries to a
+// single server. It is used instead of net.Resolver, which does not expose
+// the TTL of the records.
+type dnsClient struct {
+	server  string
+	timeout time.Duration
+}
+
+// dnsServerAddress accepts an IP address with an optional port, e.g.
+// 10.0.0.2, 10.0.0.2:5353 or [fd00::53]:53.
+func dnsServerAddress(value string) (string, error) {
+	value = strings.TrimSpace(value)
+
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+		port = defaultDNSPort
+	}
+
+	_, err = netip.ParseAddr(host)
+	if err != nil || port == "" {
+		return "", fmt.Errorf("%w: resolver %q", ErrInvalidDNSConfig, value)
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+// systemDNSServer returns the first nameserver of resolv.conf, or the local
+// host when there is none.
+func systemDNSServer() string {
+	data, err := os.ReadFile(resolvConfPath)
+	if err == nil {
+		for _, line := range lines(string(data)) {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || fields[0] != "nameserver" {
+				continue
+			}
+
+			server, err := dnsServerAddress(fields[1])
+			if err == nil {
+				return server
+			}
+		}
+	}
+
+	return net.JoinHostPort("127.0.0.1", defaultDNSPort)
+}
+
+// lookup resolves the A and AAAA records of host. A family whose query
+// fails is skipped, so a server that only breaks AAAA still yields the A
+// records. A host without any address is reported as an error so that
+// callers keep their last answer.
+func (client dnsClient) lookup(ctx context.Context, host string) (dnsAnswer, error) {
+	var (
+		result dnsAnswer
+		errs   []error
+	)
+
+	for _, qtype := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		answer, err := client.query(ctx, host, qtype)
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		if len(answer.addrs) > 0 && (len(result.addrs) == 0 || answer.ttl < result.ttl) {
+			result.ttl = answer.ttl
+		}
+
+		result.addrs = append(result.addrs, answer.addrs...)
+	}
+
+	if len(result.addrs) == 0 {
+		if len(errs) > 0 {
+			return dnsAnswer{}, errors.Join(errs...)
+		}
+
+		return dnsAnswer{}, fmt.Errorf("%w: %s has no addresses", ErrDNSQuery, host)
+	}
+
+	return result, nil
+}
+
+// query sends a single question over UDP, retrying over TCP when the answer
+// is truncated.
+func (client dnsClient) query(ctx context.Context, host string, qtype uint16) (dnsAnswer, error) {
+	id := uint16(rand.Uint32())
+
+	msg, err := buildDNSQuery(id, host, qtype)
+	if err != nil {
+		return dnsAnswer{}, err
+	}
+
+	for _, network := range []string{"udp", "tcp"} {
+		resp, err := client.exchange(ctx, network, msg)
+		if err != nil {
+			return dnsAnswer{}, fmt.Errorf("%w: %s: %w", ErrDNSQuery, host, err)
+		}
+
+		answer, truncated, err := parseDNSResponse(resp, id, qtype)
+		if err != nil {
+			return dnsAnswer{}, fmt.Errorf("%w: %s", err, host)
+		}
+
+		if !truncated {
+			return answer, nil
+		}
+	}
+
+	return dnsAnswer{}, fmt.Errorf("%w: %s: truncated over TCP", ErrDNSResponse, host)
+}
+
+func (client dnsClient) exchange(ctx context.Context, network string, msg []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, network, client.server)
+	if err != nil {
+		return nil, fmt.Errorf("error dialing %s: %w", client.server, err)
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		return nil, fmt.Errorf("error setting deadline: %w", err)
+	}
+
+	if network == "udp" {
+		_, err = conn.Write(msg)
+		if err != nil {
+			return nil, fmt.Errorf("error sending query: %w", err)
+		}
+
+		buf := make([]byte, dnsBufferSize)
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("error reading answer: %w", err)
+		}
+
+		return buf[:n], nil
+	}
+
+	// Over TCP every message is preceded by its length.
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(msg)))
+
+	_, err = conn.Write(append(framed, msg...))
+	if err != nil {
+		return nil, fmt.Errorf("error sending query: %w", err)
+	}
+
+	var length [2]byte
+
+	_, err = io.ReadFull(conn, length[:])
+	if err != nil {
+		return nil, fmt.Errorf("error reading answer: %w", err)
+	}
+
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+
+	_, err = io.ReadFull(conn, resp)
+	if err != nil {
+		return nil, fmt.Errorf("error reading answer: %w", err)
+	}
+
+	return resp, nil
+}
+
+// buildDNSQuery encodes a recursive query for one record type of host.
+func buildDNSQuery(id uint16, host string, qtype uint16) ([]byte, error) {
+	msg := make([]byte, dnsHeaderSize, dnsHeaderSize+len(host)+6)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], dnsFlagRecursion)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+
+	//nolint:modernize // yaegi does not support strings.SplitSeq
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if label == "" || len(label) > 63 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTrustedHost, host)
+		}
+
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+
+	return msg, nil
+}
+
+// parseDNSResponse reads the records of type qtype from the answer section
+// of msg. It reports a truncated answer without parsing it.
+func parseDNSResponse(msg []byte, id, qtype uint16) (dnsAnswer, bool, error) {
+	if len(msg) < dnsHeaderSize {
+		return dnsAnswer{}, false, fmt.Errorf("%w: short header", ErrDNSResponse)
+	}
+
+	flags := binary.BigEndian.Uint16(msg[2:])
+
+	if binary.BigEndian.Uint16(msg[0:]) != id || flags&dnsFlagResponse == 0 {
+		return dnsAnswer{}, false, fmt.Errorf("%w: unexpected message", ErrDNSResponse)
+	}
+
+	if flags&dnsFlagTruncated != 0 {
+		return dnsAnswer{}, true, nil
+	}
+
+	switch rcode := flags & dnsRcodeMask; rcode {
+	case 0:
+	case dnsRcodeNameError:
+		return dnsAnswer{}, false, fmt.Errorf("%w: no such host", ErrDNSQuery)
+	default:
+		return dnsAnswer{}, false, fmt.Errorf("%w: response code %d", ErrDNSQuery, rcode)
+	}
+
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	records := int(binary.BigEndian.Uint16(msg[6:]))
+	offset := dnsHeaderSize
+
+	var err error
+
+	for i := 0; i < questions; i++ {
+		offset, err = skipDNSName(msg, offset)
+		if err != nil {
+			return dnsAnswer{}, false, err
+		}
+
+		offset += 4
+	}
+
+	var answer dnsAnswer
+
+	for i := 0; i < records; i++ {
+		offset, err = skipDNSName(msg, offset)
+		if err != nil {
+			return dnsAnswer{}, false, err
+		}
+
+		if offset+10 > len(msg) {
+			return dnsAnswer{}, false, fmt.Errorf("%w: short record", ErrDNSResponse)
+		}
+
+		rtype := binary.BigEndian.Uint16(msg[offset:])
+		class := binary.BigEndian.Uint16(msg[offset+2:])
+		ttl := time.Duration(binary.BigEndian.Uint32(msg[offset+4:])) * time.Second
+		length := int(binary.BigEndian.Uint16(msg[offset+8:]))
+		offset += 10
+
+		if offset+length > len(msg) {
+			return dnsAnswer{}, false, fmt.Errorf("%w: short record", ErrDNSResponse)
+		}
+
+		data := msg[offset : offset+length]
+		offset += length
+
+		// CNAME records lead to the addresses that follow them.
+		if rtype != qtype || class != dnsClassIN {
+			continue
+		}
+
+		addr, ok := netip.AddrFromSlice(data)
+		if !ok {
+			return dnsAnswer{}, false, fmt.Errorf("%w: bad address record", ErrDNSResponse)
+		}
+
+		answer.addrs = append(answer.addrs, addr.Unmap())
+
+		if len(answer.addrs) == 1 || ttl < answer.ttl {
+			answer.ttl = ttl
+		}
+	}
+
+	return answer, false, nil
+}
+
+// skipDNSName returns the offset following the, possibly compressed, name
+// starting at offset.
+func skipDNSName(msg []byte, offset int) (int, error) {
+	for offset < len(msg) {
+		length := int(msg[offset])
+
+		switch {
+		case length == 0:
+			return offset + 1, nil
+		case length&0xc0 == 0xc0:
+			return offset + 2, nil
+		case length&0xc0 != 0:
+			return 0, fmt.Errorf("%w: bad name", ErrDNSResponse)
+		}
+
+		offset += 1 + length
+	}
+
+	return 0, fmt.Errorf("%w: short name", ErrDNSResponse)
+}
@@ -0,0 +1,303 @@
+package traefik_real_ip
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const dnsRcodeServerFailure = 2
+
+// fakeDNSServer answers A and AAAA queries over UDP and TCP from a fixed
+// set of records.
+type fakeDNSServer struct {
+	records  map[uint16][]netip.Addr
+	addr     string
+	queries  atomic.Int32
+	mu       sync.Mutex
+	ttl      uint32
+	rcode    uint16
+	failType uint16
+	truncate bool
+}
+
+func newFakeDNSServer(t *testing.T) *fakeDNSServer {
+	t.Helper()
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", packetConn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &fakeDNSServer{
+		addr:    packetConn.LocalAddr().String(),
+		records: make(map[uint16][]netip.Addr),
+	}
+
+	t.Cleanup(func() {
+		_ = packetConn.Close()
+		_ = listener.Close()
+	})
+
+	go func() {
+		buf := make([]byte, dnsBufferSize)
+
+		for {
+			n, from, err := packetConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			_, _ = packetConn.WriteTo(server.answer(buf[:n], true), from)
+		}
+	}()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			var length [2]byte
+
+			_, err = io.ReadFull(conn, length[:])
+			if err == nil {
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				_, err = io.ReadFull(conn, query)
+
+				if err == nil {
+					resp := server.answer(query, false)
+					framed := binary.BigEndian.AppendUint16(nil, uint16(len(resp)))
+					_, _ = conn.Write(append(framed, resp...))
+				}
+			}
+
+			_ = conn.Close()
+		}
+	}()
+
+	return server
+}
+
+func (server *fakeDNSServer) set(ttl uint32, rcode uint16, addrs ...string) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.ttl = ttl
+	server.rcode = rcode
+	server.records = make(map[uint16][]netip.Addr)
+
+	for _, value := range addrs {
+		addr := netip.MustParseAddr(value)
+		if addr.Is4() {
+			server.records[dnsTypeA] = append(server.records[dnsTypeA], addr)
+		} else {
+			server.records[dnsTypeAAAA] = append(server.records[dnsTypeAAAA], addr)
+		}
+	}
+}
+
+func (server *fakeDNSServer) answer(query []byte, udp bool) []byte {
+	server.queries.Add(1)
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	end, err := skipDNSName(query, dnsHeaderSize)
+	if err != nil || end+4 > len(query) {
+		return nil
+	}
+
+	qtype := binary.BigEndian.Uint16(query[end:])
+	flags := uint16(dnsFlagResponse|dnsFlagRecursion) | server.rcode
+	if qtype == server.failType {
+		flags |= dnsRcodeServerFailure
+	}
+
+	records := server.records[qtype]
+	if udp && server.truncate {
+		flags |= dnsFlagTruncated
+		records = nil
+	}
+
+	resp := append([]byte(nil), query[:2]...)
+	resp = binary.BigEndian.AppendUint16(resp, flags)
+	resp = binary.BigEndian.AppendUint16(resp, 1)
+	resp = binary.BigEndian.AppendUint16(resp, uint16(len(records)))
+	resp = append(resp, 0, 0, 0, 0)
+	resp = append(resp, query[dnsHeaderSize:end+4]...)
+
+	for _, addr := range records {
+		resp = append(resp, 0xc0, dnsHeaderSize)
+		resp = binary.BigEndian.AppendUint16(resp, qtype)
+		resp = binary.BigEndian.AppendUint16(resp, dnsClassIN)
+		resp = binary.BigEndian.AppendUint32(resp, server.ttl)
+		resp = binary.BigEndian.AppendUint16(resp, uint16(addr.BitLen()/8))
+		resp = append(resp, addr.AsSlice()...)
+	}
+
+	return resp
+}
+
+func TestDNSClient_Lookup(t *testing.T) {
+	server := newFakeDNSServer(t)
+	server.set(30, 0, "192.0.2.10", "192.0.2.11", "2001:db8::10")
+
+	client := dnsClient{server: server.addr, timeout: time.Second}
+
+	answer, err := client.lookup(t.Context(), "lb.example.com")
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+
+	if len(answer.addrs) != 3 || answer.addrs[2] != netip.MustParseAddr("2001:db8::10") {
+		t.Errorf("unexpected addresses %v", answer.addrs)
+	}
+
+	if answer.ttl != 30*time.Second {
+		t.Errorf("expected a TTL of 30s, got %v", answer.ttl)
+	}
+}
+
+func TestDNSClient_TruncatedAnswerUsesTCP(t *testing.T) {
+	server := newFakeDNSServer(t)
+	server.set(30, 0, "192.0.2.10")
+
+	server.mu.Lock()
+	server.truncate = true
+	server.mu.Unlock()
+
+	client := dnsClient{server: server.addr, timeout: time.Second}
+
+	answer, err := client.lookup(t.Context(), "lb.example.com")
+	if err != nil {
+		t.Fatalf("lookup: %v", err)
+	}
+
+	if len(answer.addrs) != 1 || answer.addrs[0] != netip.MustParseAddr("192.0.2.10") {
+		t.Errorf("unexpected addresses %v", answer.addrs)
+	}
+}
+
+func TestDNSClient_LookupToleratesOneFailingFamily(t *testing.T) {
+	server := newFakeDNSServer(t)
+	client := dnsClient{server: server.addr, timeout: time.Second}
+
+	for _, failType := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		server.set(30, 0, "192.0.2.10", "2001:db8::10")
+
+		server.mu.Lock()
+		server.failType = failType
+		server.mu.Unlock()
+
+		answer, err := client.lookup(t.Context(), "lb.example.com")
+		if err != nil {
+			t.Fatalf("type %d failing: lookup: %v", failType, err)
+		}
+
+		if len(answer.addrs) != 1 {
+			t.Errorf("type %d failing: expected the other family, got %v", failType, answer.addrs)
+		}
+	}
+
+	// Both families failing is still an error.
+	server.set(30, dnsRcodeServerFailure, "192.0.2.10")
+
+	_, err := client.lookup(t.Context(), "lb.example.com")
+	if !errors.Is(err, ErrDNSQuery) {
+		t.Errorf("expected ErrDNSQuery when both families fail, got %v", err)
+	}
+}
+
+func TestDNSClient_LookupErrors(t *testing.T) {
+	server := newFakeDNSServer(t)
+	client := dnsClient{server: server.addr, timeout: time.Second}
+
+	server.set(30, dnsRcodeNameError)
+
+	_, err := client.lookup(t.Context(), "missing.example.com")
+	if !errors.Is(err, ErrDNSQuery) {
+		t.Errorf("expected ErrDNSQuery for NXDOMAIN, got %v", err)
+	}
+
+	server.set(30, 0)
+
+	_, err = client.lookup(t.Context(), "empty.example.com")
+	if !errors.Is(err, ErrDNSQuery) {
+		t.Errorf("expected ErrDNSQuery without addresses, got %v", err)
+	}
+
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	client = dnsClient{server: silent.LocalAddr().String(), timeout: 20 * time.Millisecond}
+
+	_, err = client.lookup(t.Context(), "lb.example.com")
+	if !errors.Is(err, ErrDNSQuery) {
+		t.Errorf("expected ErrDNSQuery on timeout, got %v", err)
+	}
+}
+
+func TestParseDNSResponse_Malformed(t *testing.T) {
+	query, err := buildDNSQuery(7, "lb.example.com", dnsTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	responses := map[string][]byte{
+		"short header": query[:4],
+		"not a reply":  query,
+		"other id":     append([]byte{0, 8, 0x81, 0x80}, query[4:]...),
+		"short name":   append([]byte{0, 7, 0x81, 0x80}, query[4:dnsHeaderSize+3]...),
+		"short record": append(
+			append([]byte{0, 7, 0x81, 0x80, 0, 1, 0, 1, 0, 0, 0, 0}, query[dnsHeaderSize:]...),
+			0xc0, dnsHeaderSize, 0, dnsTypeA,
+		),
+	}
+
+	for name, resp := range responses {
+		_, _, err := parseDNSResponse(resp, 7, dnsTypeA)
+		if !errors.Is(err, ErrDNSResponse) {
+			t.Errorf("%s: expected ErrDNSResponse, got %v", name, err)
+		}
+	}
+}
+
+func TestDNSServerAddress(t *testing.T) {
+	tests := map[string]string{
+		"10.0.0.2":         "10.0.0.2:53",
+		" 10.0.0.2:5353 ":  "10.0.0.2:5353",
+		"fd00::53":         "[fd00::53]:53",
+		"[fd00::53]":       "[fd00::53]:53",
+		"[fd00::53]:5353":  "[fd00::53]:5353",
+		"dns.example.com":  "",
+		"10.0.0.2:":        "",
+		"dns.example.com:": "",
+	}
+
+	for value, expected := range tests {
+		server, err := dnsServerAddress(value)
+
+		switch {
+		case expected == "" && !errors.Is(err, ErrInvalidDNSConfig):
+			t.Errorf("%q: expected ErrInvalidDNSConfig, got %q, %v", value, server, err)
+		case expected != "" && server != expected:
+			t.Errorf("%q: expected %q, got %q, %v", value, expected, server, err)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package traefik_real_ip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidDockerConfig = errors.New("invalid Docker configuration")
+	ErrDockerAPI           = errors.New("docker API request failed")
+)
+
+const (
+	dockerLabelPrefix            = "docker:"
+	defaultDockerEndpoint        = "/var/run/docker.sock"
+	defaultDockerRefreshInterval = 30 * time.Second
+	defaultDockerTimeout         = 5 * time.Second
+	maxDockerResponseSize        = 1 << 20
+)
+
+// DockerConfig selects the Docker networks whose subnets are trusted.
+type DockerConfig struct {
+	Endpoint        string   `json:"endpoint,omitempty"`
+	Timeout         string   `json:"timeout,omitempty"`
+	RefreshInterval string   `json:"refreshInterval,omitempty"`
+	Networks        []string `json:"networks,omitempty"`
+}
+
+// dockerSettings is the validated form of a DockerConfig.
+type dockerSettings struct {
+	client   *http.Client
+	networks []string
+	interval time.Duration
+}
+
+// dockerNetwork is the part of the Engine API network inspect response that
+// is needed here.
+type dockerNetwork struct {
+	IPAM dockerIPAM `json:"IPAM"`
+}
+
+type dockerIPAM struct {
+	Config []dockerIPAMConfig `json:"Config"`
+}
+
+type dockerIPAMConfig struct {
+	Subnet string `json:"Subnet"`
+}
+
+func parseDockerConfig(config DockerConfig) (dockerSettings, error) {
+	settings := dockerSettings{interval: defaultDockerRefreshInterval}
+	timeout := defaultDockerTimeout
+
+	durations := []struct {
+		target *time.Duration
+		field  string
+		value  string
+	}{
+		{target: &timeout, field: "timeout", value: config.Timeout},
+		{target: &settings.interval, field: "refreshInterval", value: config.RefreshInterval},
+	}
+
+	for _, duration := range durations {
+		if duration.value == "" {
+			continue
+		}
+
+		parsed, err := time.ParseDuration(duration.value)
+		if err != nil || parsed < 0 {
+			return dockerSettings{}, fmt.Errorf(
+				"%w: %s: %q", ErrInvalidDockerConfig, duration.field, duration.value,
+			)
+		}
+
+		*duration.target = parsed
+	}
+
+	if timeout == 0 {
+		return dockerSettings{}, fmt.Errorf("%w: timeout must be positive", ErrInvalidDockerConfig)
+	}
+
+	seen := make(map[string]bool, len(config.Networks))
+
+	for _, name := range config.Networks {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return dockerSettings{}, fmt.Errorf("%w: empty network name", ErrInvalidDockerConfig)
+		}
+
+		if !seen[name] {
+			seen[name] = true
+			settings.networks = append(settings.networks, name)
+		}
+	}
+
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = defaultDockerEndpoint
+	}
+
+	socket := strings.TrimPrefix(endpoint, "unix://")
+	if strings.Contains(socket, "://") || socket == "" {
+		return dockerSettings{}, fmt.Errorf(
+			"%w: endpoint must be a unix socket: %q", ErrInvalidDockerConfig, endpoint,
+		)
+	}
+
+	var dialer net.Dialer
+
+	settings.client = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	return settings, nil
+}
+
+// watchDockerNetworks trusts the subnets of the configured networks and
+// refreshes them every interval until ctx is done.
+func (resolver *IPResolver) watchDockerNetworks(ctx context.Context) {
+	if len(resolver.docker.networks) == 0 {
+		return
+	}
+
+	subnets := make(map[string][]netip.Prefix, len(resolver.docker.networks))
+	resolver.refreshDockerNetworks(ctx, subnets)
+
+	if resolver.docker.interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(resolver.docker.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				resolver.refreshDockerNetworks(ctx, subnets)
+			}
+		}
+	}()
+}
+
+// refreshDockerNetworks installs the subnets that differ from the last good
+// ones in subnets. A network that cannot be inspected keeps its subnets.
+func (resolver *IPResolver) refreshDockerNetworks(
+	ctx context.Context,
+	subnets map[string][]netip.Prefix,
+) {
+	updates := make(map[string][]netip.Prefix)
+
+	for _, name := range resolver.docker.networks {
+		prefixes, err := resolver.inspectDockerNetwork(ctx, name)
+		if err != nil {
+			resolver.logger.ErrorContext(
+				ctx,
+				"Error inspecting Docker network, keeping the last good subnets",
+				slog.String("network", name),
+				slog.Int("count", len(subnets[name])),
+				slog.Any("error", err),
+			)
+
+			continue
+		}
+
+		if equalPrefixes(prefixes, subnets[name]) {
+			continue
+		}
+
+		subnets[name] = prefixes
+		updates[dockerLabelPrefix+name] = prefixes
+
+		resolver.logger.InfoContext(
+			ctx,
+			"Discovered Docker network subnets",
+			slog.String("network", name),
+			slog.Int("count", len(prefixes)),
+		)
+	}
+
+	if len(updates) > 0 {
+		resolver.setTrustedSources(updates)
+	}
+}
+
+// inspectDockerNetwork returns the IPAM subnets of the named network.
+func (resolver *IPResolver) inspectDockerNetwork(
+	ctx context.Context,
+	name string,
+) ([]netip.Prefix, error) {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		"http://docker/networks/"+url.PathEscape(name),
+		http.NoBody,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating HTTP request: %w", err)
+	}
+
+	resp, err := resolver.docker.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDockerAPI, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrDockerAPI, resp.Status)
+	}
+
+	var network dockerNetwork
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxDockerResponseSize)).Decode(&network)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error decoding response: %w", ErrDockerAPI, err)
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(network.IPAM.Config))
+
+	for _, config := range network.IPAM.Config {
+		if config.Subnet == "" {
+			continue
+		}
+
+		prefix, err := parsePrefix(config.Subnet)
+		if err != nil {
+			return nil, fmt.Errorf("%w: subnet %q: %w", ErrDockerAPI, config.Subnet, err)
+		}
+
+		prefixes = append(prefixes, prefix)
+	}
+
+	if len(prefixes) == 0 {
+		return nil, fmt.Errorf("%w: network has no subnets", ErrDockerAPI)
+	}
+
+	return prefixes, nil
+}
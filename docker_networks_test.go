@@ -0,0 +1,177 @@
+package traefik_real_ip
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDockerEngine serves network inspect responses on a unix socket.
+type fakeDockerEngine struct {
+	subnets map[string][]string
+	socket  string
+	mu      sync.Mutex
+}
+
+func newFakeDockerEngine(t *testing.T) *fakeDockerEngine {
+	t.Helper()
+
+	// Socket paths are limited to about 100 bytes, shorter than many TempDirs.
+	dir, err := os.MkdirTemp("", "docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	engine := &fakeDockerEngine{
+		socket:  filepath.Join(dir, "docker.sock"),
+		subnets: make(map[string][]string),
+	}
+
+	listener, err := net.Listen("unix", engine.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &http.Server{
+		Handler:           http.HandlerFunc(engine.serveHTTP),
+		ReadHeaderTimeout: time.Second,
+	}
+
+	go func() { _ = server.Serve(listener) }()
+
+	t.Cleanup(func() { _ = server.Close() })
+
+	return engine
+}
+
+func (engine *fakeDockerEngine) set(name string, subnets ...string) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+
+	engine.subnets[name] = subnets
+}
+
+func (engine *fakeDockerEngine) serveHTTP(rw http.ResponseWriter, req *http.Request) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+
+	subnets, ok := engine.subnets[strings.TrimPrefix(req.URL.Path, "/networks/")]
+	if !ok {
+		http.Error(rw, `{"message":"network not found"}`, http.StatusNotFound)
+
+		return
+	}
+
+	network := dockerNetwork{}
+	for _, subnet := range subnets {
+		network.IPAM.Config = append(network.IPAM.Config, dockerIPAMConfig{Subnet: subnet})
+	}
+
+	_ = json.NewEncoder(rw).Encode(network)
+}
+
+func TestParseDockerConfig(t *testing.T) {
+	settings, err := parseDockerConfig(DockerConfig{
+		Endpoint: "unix:///run/docker.sock",
+		Networks: []string{" proxy ", "proxy", "edge"},
+	})
+	if err != nil {
+		t.Fatalf("parseDockerConfig: %v", err)
+	}
+
+	if len(settings.networks) != 2 || settings.networks[0] != "proxy" ||
+		settings.interval != defaultDockerRefreshInterval {
+		t.Errorf("unexpected settings %+v", settings)
+	}
+
+	for _, config := range []DockerConfig{
+		{Endpoint: "tcp://127.0.0.1:2375"},
+		{Networks: []string{" "}},
+		{RefreshInterval: "often"},
+		{Timeout: "0s"},
+	} {
+		_, err := parseDockerConfig(config)
+		if !errors.Is(err, ErrInvalidDockerConfig) {
+			t.Errorf("%+v: expected ErrInvalidDockerConfig, got %v", config, err)
+		}
+	}
+}
+
+func TestNew_DockerNetworks(t *testing.T) {
+	engine := newFakeDockerEngine(t)
+	engine.set("traefik-real-ip_proxy", "172.20.0.0/16", "fd00:20::/64")
+
+	resolver := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.Docker = DockerConfig{
+			Endpoint:        engine.socket,
+			Networks:        []string{"traefik-real-ip_proxy", "missing"},
+			RefreshInterval: "5ms",
+		}
+	})
+
+	ctx := t.Context()
+
+	trusted, provider := resolver.matchTrustedIP(ctx, netip.MustParseAddr("172.20.0.2"))
+	if !trusted || provider != "docker:traefik-real-ip_proxy" {
+		t.Errorf("expected the network subnet to be trusted, got %v %q", trusted, provider)
+	}
+
+	if !resolver.isTrustedIP(ctx, netip.MustParseAddr("fd00:20::2")) {
+		t.Error("expected the IPv6 subnet to be trusted")
+	}
+
+	// A recreated network gets a new subnet.
+	engine.set("traefik-real-ip_proxy", "172.21.0.0/16")
+
+	waitFor(t, func() bool {
+		return resolver.isTrustedIP(ctx, netip.MustParseAddr("172.21.0.2")) &&
+			!resolver.isTrustedIP(ctx, netip.MustParseAddr("172.20.0.2"))
+	})
+
+	engine.set("missing", "172.22.0.0/16")
+
+	waitFor(t, func() bool {
+		return resolver.isTrustedIP(ctx, netip.MustParseAddr("172.22.0.2"))
+	})
+}
+
+func TestInspectDockerNetwork_Errors(t *testing.T) {
+	engine := newFakeDockerEngine(t)
+	engine.set("host")
+	engine.set("broken", "not-a-subnet")
+
+	settings, err := parseDockerConfig(DockerConfig{Endpoint: engine.socket})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := newTestResolver(t)
+	resolver.docker = settings
+
+	for _, name := range []string{"host", "broken", "missing"} {
+		_, err := resolver.inspectDockerNetwork(t.Context(), name)
+		if !errors.Is(err, ErrDockerAPI) {
+			t.Errorf("%s: expected ErrDockerAPI, got %v", name, err)
+		}
+	}
+
+	resolver.docker, err = parseDockerConfig(DockerConfig{Endpoint: engine.socket + ".gone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = resolver.inspectDockerNetwork(t.Context(), "host")
+	if !errors.Is(err, ErrDockerAPI) {
+		t.Errorf("expected ErrDockerAPI without a socket, got %v", err)
+	}
+}
@@ -2,33 +2,25 @@ package traefik_real_ip
 
 import (
 	"context"
-	"net"
-	"sync"
+	"net/netip"
 )
 
 const (
 	edgeOneIPURL = "https://raw.githubusercontent.com/zekihan/traefik-real-ip/refs/heads/main/remote_ips/edgeone"
 )
 
-var (
-	edgeOneIPsInstance []*net.IPNet
-	edgeOneIPsOnce     sync.Once
-)
-
 var edgeOneProvider = remoteIPProvider{
-	name:  "EdgeOne",
-	urls:  []string{edgeOneIPURL},
-	once:  &edgeOneIPsOnce,
-	cache: &edgeOneIPsInstance,
+	name: "EdgeOne",
+	urls: []string{edgeOneIPURL},
 }
 
-func (resolver *IPResolver) getEdgeOneIPs(ctx context.Context) []*net.IPNet {
+func (resolver *IPResolver) getEdgeOneIPs(ctx context.Context) []netip.Prefix {
 	return resolver.getProviderIPs(ctx, edgeOneProvider)
 }
 
 func (resolver *IPResolver) getEdgeOneIPFromURL(
 	ctx context.Context,
 	url string,
-) ([]*net.IPNet, error) {
+) ([]netip.Prefix, error) {
 	return resolver.getProviderIPsFromURL(ctx, edgeOneProvider.name, url)
 }
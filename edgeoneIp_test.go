@@ -3,7 +3,6 @@ package traefik_real_ip
 import (
 	"net/http"
 	"net/http/httptest"
-	"sync"
 	"testing"
 )
 
@@ -37,29 +36,16 @@ func TestIPResolver_getEdgeOneIPs(t *testing.T) {
 	defer ipv6Server.Close()
 
 	originalProvider := edgeOneProvider
-	originalInstance := edgeOneIPsInstance
-
-	// Use local once and cache variables to avoid package-level state issues.
-	var localOnce sync.Once
-
-	edgeOneIPsInstance = nil
 	edgeOneProvider = remoteIPProvider{
-		name:  originalProvider.name,
-		urls:  []string{ipv4Server.URL, ipv6Server.URL},
-		once:  &localOnce,
-		cache: &edgeOneIPsInstance,
+		name: originalProvider.name,
+		urls: []string{ipv4Server.URL, ipv6Server.URL},
 	}
 
 	defer func() {
 		edgeOneProvider = originalProvider
-		edgeOneIPsInstance = originalInstance
 	}()
 
 	t.Run("singleton behavior", func(t *testing.T) {
-		// Reset local once and cache for each subtest run.
-		localOnce = sync.Once{}
-		edgeOneIPsInstance = nil
-
 		ips1 := resolver.getEdgeOneIPs(t.Context())
 		ips2 := resolver.getEdgeOneIPs(t.Context())
 
@@ -0,0 +1,156 @@
+package traefik_real_ip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+var ErrInvalidEmbeddedIPv4Config = errors.New("invalid embedded IPv4 configuration")
+
+var (
+	// wellKnownNAT64Prefix is the NAT64 prefix of RFC 6052.
+	wellKnownNAT64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFourPrefix      = netip.MustParsePrefix("2002::/16")
+)
+
+// EmbeddedIPv4Config enables a header carrying the IPv4 address of the
+// client, unwrapped from its IPv6 address when needed.
+type EmbeddedIPv4Config struct {
+	Header        string   `json:"header,omitempty"`
+	NAT64Prefixes []string `json:"nat64Prefixes,omitempty"`
+}
+
+// embeddedIPv4Settings is the validated form of an EmbeddedIPv4Config. An
+// empty header disables the feature.
+type embeddedIPv4Settings struct {
+	header        string
+	nat64Prefixes []netip.Prefix
+}
+
+func parseEmbeddedIPv4Config(config EmbeddedIPv4Config) (embeddedIPv4Settings, error) {
+	header := strings.TrimSpace(config.Header)
+	if header == "" {
+		if len(config.NAT64Prefixes) > 0 {
+			return embeddedIPv4Settings{}, fmt.Errorf(
+				"%w: nat64Prefixes requires a header", ErrInvalidEmbeddedIPv4Config,
+			)
+		}
+
+		return embeddedIPv4Settings{}, nil
+	}
+
+	settings := embeddedIPv4Settings{
+		header:        http.CanonicalHeaderKey(header),
+		nat64Prefixes: []netip.Prefix{wellKnownNAT64Prefix},
+	}
+
+	for _, value := range config.NAT64Prefixes {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(value))
+		if err != nil || !prefix.Addr().Is6() || prefix.Addr().Is4In6() ||
+			!validNAT64PrefixLength(prefix.Bits()) {
+			return embeddedIPv4Settings{}, fmt.Errorf(
+				"%w: nat64Prefixes: %q is not an IPv6 prefix of length 32, 40, 48, 56, 64 or 96",
+				ErrInvalidEmbeddedIPv4Config, value,
+			)
+		}
+
+		prefix = prefix.Masked()
+		if prefix != wellKnownNAT64Prefix {
+			settings.nat64Prefixes = append(settings.nat64Prefixes, prefix)
+		}
+	}
+
+	// The longest prefix wins when configured prefixes overlap.
+	sort.SliceStable(settings.nat64Prefixes, func(i, j int) bool {
+		return settings.nat64Prefixes[i].Bits() > settings.nat64Prefixes[j].Bits()
+	})
+
+	return settings, nil
+}
+
+// validNAT64PrefixLength reports whether bits is one of the prefix lengths
+// defined by RFC 6052 section 2.2.
+func validNAT64PrefixLength(bits int) bool {
+	switch bits {
+	case 32, 40, 48, 56, 64, 96:
+		return true
+	default:
+		return false
+	}
+}
+
+// embeddedIPv4 returns the IPv4 address of ip: ip itself, the address of an
+// IPv4-mapped address, or the address embedded in a NAT64 or 6to4 address.
+func (settings embeddedIPv4Settings) embeddedIPv4(ip netip.Addr) (netip.Addr, bool) {
+	if ip.Is4() || ip.Is4In6() {
+		return ip.Unmap(), true
+	}
+
+	for _, prefix := range settings.nat64Prefixes {
+		if prefix.Contains(ip) {
+			return nat64EmbeddedIPv4(ip, prefix.Bits()), true
+		}
+	}
+
+	if sixToFourPrefix.Contains(ip) {
+		raw := ip.As16()
+
+		return netip.AddrFrom4([4]byte{raw[2], raw[3], raw[4], raw[5]}), true
+	}
+
+	return netip.Addr{}, false
+}
+
+// nat64EmbeddedIPv4 extracts the IPv4 address following a NAT64 prefix of
+// the given length, skipping the reserved octet at bits 64 to 71.
+func nat64EmbeddedIPv4(ip netip.Addr, bits int) netip.Addr {
+	raw := ip.As16()
+
+	var embedded [4]byte
+
+	index := bits / 8
+	for i := 0; i < len(embedded); i++ {
+		if index == 8 {
+			index++
+		}
+
+		embedded[i] = raw[index]
+		index++
+	}
+
+	return netip.AddrFrom4(embedded)
+}
+
+// setEmbeddedIPv4Header sets the configured header to the IPv4 address of
+// the client IP, or removes it when there is none so that a value sent by the
+// client never reaches the backend.
+func (resolver *IPResolver) setEmbeddedIPv4Header(
+	ctx context.Context,
+	req *http.Request,
+	ip netip.Addr,
+) {
+	if resolver.embeddedIPv4.header == "" {
+		return
+	}
+
+	embedded, ok := resolver.embeddedIPv4.embeddedIPv4(ip)
+	if !ok {
+		req.Header.Del(resolver.embeddedIPv4.header)
+
+		return
+	}
+
+	req.Header.Set(resolver.embeddedIPv4.header, embedded.String())
+	resolver.logger.DebugContext(
+		ctx,
+		"Setting header",
+		slog.String("header", resolver.embeddedIPv4.header),
+		slog.String("value", embedded.String()),
+	)
+}
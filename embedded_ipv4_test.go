@@ -0,0 +1,142 @@
+package traefik_real_ip
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestParseEmbeddedIPv4Config(t *testing.T) {
+	settings, err := parseEmbeddedIPv4Config(EmbeddedIPv4Config{})
+	if err != nil || settings.header != "" {
+		t.Fatalf("expected a disabled header, got %+v, %v", settings, err)
+	}
+
+	settings, err = parseEmbeddedIPv4Config(EmbeddedIPv4Config{
+		Header:        "x-real-ipv4",
+		NAT64Prefixes: []string{"2001:db8:122::/48", "64:ff9b::/96"},
+	})
+	if err != nil {
+		t.Fatalf("parseEmbeddedIPv4Config: %v", err)
+	}
+
+	if settings.header != "X-Real-Ipv4" || len(settings.nat64Prefixes) != 2 {
+		t.Errorf("unexpected settings %+v", settings)
+	}
+
+	for _, config := range []EmbeddedIPv4Config{
+		{NAT64Prefixes: []string{"2001:db8::/32"}},
+		{Header: "X-Real-IPv4", NAT64Prefixes: []string{"2001:db8::/44"}},
+		{Header: "X-Real-IPv4", NAT64Prefixes: []string{"10.0.0.0/8"}},
+		{Header: "X-Real-IPv4", NAT64Prefixes: []string{"nat64"}},
+	} {
+		_, err := parseEmbeddedIPv4Config(config)
+		if !errors.Is(err, ErrInvalidEmbeddedIPv4Config) {
+			t.Errorf("%+v: expected ErrInvalidEmbeddedIPv4Config, got %v", config, err)
+		}
+	}
+}
+
+func TestEmbeddedIPv4(t *testing.T) {
+	settings, err := parseEmbeddedIPv4Config(EmbeddedIPv4Config{
+		Header: "X-Real-IPv4",
+		NAT64Prefixes: []string{
+			"2001:db8::/32", "2001:db8:100::/40", "2001:db8:122::/48",
+			"2001:db8:122:300::/56", "2001:db8:122:344::/64",
+		},
+	})
+	if err != nil {
+		t.Fatalf("parseEmbeddedIPv4Config: %v", err)
+	}
+
+	// The NAT64 examples are those of RFC 6052 section 2.4 for 192.0.2.33.
+	tests := map[string]string{
+		"9.9.9.9":                      "9.9.9.9",
+		"::ffff:9.9.9.9":               "9.9.9.9",
+		"64:ff9b::909:909":             "9.9.9.9",
+		"2001:db8:c000:221::":          "192.0.2.33",
+		"2001:db8:1c0:2:21::":          "192.0.2.33",
+		"2001:db8:122:c000:2:2100::":   "192.0.2.33",
+		"2001:db8:122:3c0:0:221::":     "192.0.2.33",
+		"2001:db8:122:344:c0:2:2100:0": "192.0.2.33",
+		"2002:909:909::1":              "9.9.9.9",
+		"2606:4700::1":                 "",
+		"2001:db9::1":                  "",
+	}
+
+	for ip, expected := range tests {
+		embedded, ok := settings.embeddedIPv4(netip.MustParseAddr(ip))
+		if ok != (expected != "") || (ok && embedded.String() != expected) {
+			t.Errorf("%s: expected %q, got %v %v", ip, expected, embedded, ok)
+		}
+	}
+}
+
+func TestIPResolver_ServeHTTP_EmbeddedIPv4(t *testing.T) {
+	resolver := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.TrustedIPs = []string{"10.0.0.0/8"}
+		cfg.EmbeddedIPv4 = EmbeddedIPv4Config{Header: "X-Real-IPv4"}
+	})
+
+	tests := map[string]string{
+		"64:ff9b::909:909": "9.9.9.9",
+		"2606:4700::1":     "",
+	}
+
+	for client, expected := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set(XForwardedFor, client)
+		req.Header.Set("X-Real-IPv4", "1.1.1.1")
+
+		resolver.ServeHTTP(httptest.NewRecorder(), req)
+
+		if req.Header.Get(XRealIP) != client {
+			t.Errorf("%s: expected X-Real-IP to keep the IPv6 address, got %s",
+				client, req.Header.Get(XRealIP))
+		}
+
+		if req.Header.Get("X-Real-IPv4") != expected {
+			t.Errorf("%s: expected X-Real-IPv4 %q, got %q",
+				client, expected, req.Header.Get("X-Real-IPv4"))
+		}
+	}
+}
+
+func TestIPResolver_ServeHTTP_EmbeddedIPv4LocalUsePrefix(t *testing.T) {
+	const client = "64:ff9b:1:909:9:900::"
+
+	for _, public := range []bool{false, true} {
+		resolver := newConfiguredResolver(t, func(cfg *Config) {
+			cfg.TrustedIPs = []string{"10.0.0.0/8"}
+			cfg.EmbeddedIPv4 = EmbeddedIPv4Config{
+				Header:        "X-Real-IPv4",
+				NAT64Prefixes: []string{"64:ff9b:1::/48"},
+			}
+
+			if public {
+				cfg.NonPublicRanges = map[string]bool{NonPublicTranslation: false}
+			}
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set(XForwardedFor, client)
+
+		resolver.ServeHTTP(httptest.NewRecorder(), req)
+
+		// The local-use prefix is non-public, so the address only becomes the
+		// client IP once the translation category is disabled.
+		expected := ""
+		if public {
+			expected = "9.9.9.9"
+		}
+
+		if req.Header.Get("X-Real-IPv4") != expected {
+			t.Errorf("translation public=%t: expected X-Real-IPv4 %q, got %q",
+				public, expected, req.Header.Get("X-Real-IPv4"))
+		}
+	}
+}
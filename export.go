@@ -0,0 +1,290 @@
+package traefik_real_ip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownExportFormat = errors.New("unknown export format")
+	ErrInvalidExportConfig = errors.New("invalid export configuration")
+)
+
+const (
+	defaultExportInterval = time.Minute
+	exportMiddlewareName  = "trusted-ips"
+	exportIPSetName       = "traefik-real-ip"
+)
+
+// ExportConfig enables writing the trusted set to a file.
+type ExportConfig struct {
+	Path     string `json:"path,omitempty"`
+	Format   string `json:"format,omitempty"`
+	Interval string `json:"interval,omitempty"`
+}
+
+// exportSettings is the validated form of an ExportConfig.
+type exportSettings struct {
+	path     string
+	format   string
+	interval time.Duration
+}
+
+// TrustedRange is a trusted prefix together with the providers it comes from.
+type TrustedRange struct {
+	Prefix    netip.Prefix `json:"cidr"`
+	Providers []string     `json:"providers"`
+}
+
+func parseExportFormat(format string) (string, error) {
+	switch format = strings.ToLower(strings.TrimSpace(format)); format {
+	case ExportFormatTraefik, ExportFormatNginx, ExportFormatIPSet, ExportFormatJSON:
+		return format, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownExportFormat, format)
+	}
+}
+
+func parseExportConfig(config ExportConfig) (exportSettings, error) {
+	if config.Path == "" {
+		return exportSettings{}, nil
+	}
+
+	format, err := parseExportFormat(config.Format)
+	if err != nil {
+		return exportSettings{}, fmt.Errorf("%w: format: %w", ErrInvalidExportConfig, err)
+	}
+
+	interval := defaultExportInterval
+
+	if config.Interval != "" {
+		interval, err = time.ParseDuration(config.Interval)
+		if err != nil || interval <= 0 {
+			return exportSettings{}, fmt.Errorf(
+				"%w: interval: %q", ErrInvalidExportConfig, config.Interval,
+			)
+		}
+	}
+
+	return exportSettings{path: config.Path, format: format, interval: interval}, nil
+}
+
+// TrustedRanges returns the current trusted set, deduplicated and sorted,
+// with the providers of every prefix.
+func (resolver *IPResolver) TrustedRanges() []TrustedRange {
+	resolver.trustMu.RLock()
+	defer resolver.trustMu.RUnlock()
+
+	ranges := make([]TrustedRange, 0, len(resolver.trustedIPNets))
+	seen := make(map[netip.Prefix]bool, len(resolver.trustedIPNets))
+
+	for _, prefix := range resolver.trustedIPNets {
+		if seen[prefix] {
+			continue
+		}
+
+		seen[prefix] = true
+
+		providers := make([]string, 0, 1)
+
+		if labels := resolver.trustedIPProviders[prefix]; labels != "" {
+			//nolint:modernize // yaegi does not support strings.SplitSeq
+			providers = append(providers, strings.Split(labels, ",")...)
+		}
+
+		ranges = append(ranges, TrustedRange{Prefix: prefix, Providers: providers})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		left, right := ranges[i].Prefix, ranges[j].Prefix
+		if left.Addr() != right.Addr() {
+			return left.Addr().Less(right.Addr())
+		}
+
+		return left.Bits() < right.Bits()
+	})
+
+	return ranges
+}
+
+// WriteTrustedRanges writes ranges in one of the export formats:
+//
+//   - traefik: dynamic configuration of an ipAllowList middleware
+//   - nginx: set_real_ip_from directives
+//   - ipset: ipset restore input with one set per address family
+//   - json: an array of objects with the CIDR and its providers
+func WriteTrustedRanges(out io.Writer, format string, ranges []TrustedRange) error {
+	format, err := parseExportFormat(format)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+
+	switch format {
+	case ExportFormatTraefik:
+		writeTraefikRanges(&buf, ranges)
+	case ExportFormatNginx:
+		for _, trusted := range ranges {
+			fmt.Fprintf(&buf, "set_real_ip_from %s;%s\n", trusted.Prefix, exportComment(trusted))
+		}
+	case ExportFormatIPSet:
+		writeIPSetRanges(&buf, ranges)
+	default:
+		encoder := json.NewEncoder(&buf)
+		encoder.SetIndent("", "  ")
+
+		err = encoder.Encode(ranges)
+		if err != nil {
+			return fmt.Errorf("error encoding trusted ranges: %w", err)
+		}
+	}
+
+	_, err = out.Write(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("error writing trusted ranges: %w", err)
+	}
+
+	return nil
+}
+
+func writeTraefikRanges(buf *bytes.Buffer, ranges []TrustedRange) {
+	buf.WriteString("http:\n  middlewares:\n    " + exportMiddlewareName + ":\n")
+	buf.WriteString("      ipAllowList:\n        sourceRange:")
+
+	if len(ranges) == 0 {
+		buf.WriteString(" []\n")
+
+		return
+	}
+
+	buf.WriteString("\n")
+
+	for _, trusted := range ranges {
+		fmt.Fprintf(buf, "          - %q%s\n", trusted.Prefix.String(), exportComment(trusted))
+	}
+}
+
+func writeIPSetRanges(buf *bytes.Buffer, ranges []TrustedRange) {
+	sets := []struct {
+		name   string
+		family string
+		is4    bool
+	}{
+		{name: exportIPSetName + "-v4", family: "inet", is4: true},
+		{name: exportIPSetName + "-v6", family: "inet6", is4: false},
+	}
+
+	for _, set := range sets {
+		fmt.Fprintf(buf, "create %s hash:net family %s comment -exist\n", set.name, set.family)
+		fmt.Fprintf(buf, "flush %s\n", set.name)
+
+		for _, trusted := range ranges {
+			if trusted.Prefix.Addr().Is4() != set.is4 {
+				continue
+			}
+
+			fmt.Fprintf(buf, "add %s %s comment %q -exist\n",
+				set.name, trusted.Prefix, strings.Join(trusted.Providers, ","))
+		}
+	}
+}
+
+func exportComment(trusted TrustedRange) string {
+	if len(trusted.Providers) == 0 {
+		return ""
+	}
+
+	return " # " + strings.Join(trusted.Providers, ",")
+}
+
+// exportTrustedRanges periodically writes the trusted set to the configured
+// file until ctx is done.
+func (resolver *IPResolver) exportTrustedRanges(ctx context.Context) {
+	if resolver.export.path == "" {
+		return
+	}
+
+	var last []byte
+
+	write := func() {
+		var buf bytes.Buffer
+
+		err := WriteTrustedRanges(&buf, resolver.export.format, resolver.TrustedRanges())
+		if err == nil && !bytes.Equal(buf.Bytes(), last) {
+			err = writeFileAtomic(resolver.export.path, buf.Bytes())
+		}
+
+		if err != nil {
+			resolver.logger.ErrorContext(
+				ctx,
+				"Error exporting trusted IPs",
+				slog.String("path", resolver.export.path),
+				slog.Any("error", err),
+			)
+
+			return
+		}
+
+		last = buf.Bytes()
+	}
+
+	write()
+
+	go func() {
+		ticker := time.NewTicker(resolver.export.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				write()
+			}
+		}
+	}()
+}
+
+// writeFileAtomic replaces path with data through a rename so that readers
+// never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %w", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0o644)
+	}
+
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", tmp.Name(), err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("error renaming %s: %w", tmp.Name(), err)
+	}
+
+	return nil
+}
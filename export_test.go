@@ -0,0 +1,225 @@
+package traefik_real_ip
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func exportTestRanges() []TrustedRange {
+	return []TrustedRange{
+		{Prefix: netip.MustParsePrefix("173.245.48.0/20"), Providers: []string{"cloudflare"}},
+		{
+			Prefix:    netip.MustParsePrefix("2400:cb00::/32"),
+			Providers: []string{"cloudflare", "custom"},
+		},
+		{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Providers: []string{}},
+	}
+}
+
+func TestTrustedRanges(t *testing.T) {
+	resolver := newTestResolver(t)
+	resolver.setTrustedSources(map[string][]netip.Prefix{
+		"custom": {
+			netip.MustParsePrefix("2400:cb00::/32"),
+			netip.MustParsePrefix("10.0.0.0/8"),
+		},
+		"cloudflare": {
+			netip.MustParsePrefix("173.245.48.0/20"),
+			netip.MustParsePrefix("2400:cb00::/32"),
+			netip.MustParsePrefix("10.0.0.0/16"),
+		},
+	})
+
+	ranges := resolver.TrustedRanges()
+
+	want := []string{
+		"10.0.0.0/8 cloudflare,custom",
+		"173.245.48.0/20 cloudflare",
+		"2400:cb00::/32 cloudflare,custom",
+	}
+
+	if len(ranges) != len(want) {
+		t.Fatalf("expected %d ranges, got %v", len(want), ranges)
+	}
+
+	for i, trusted := range ranges {
+		got := trusted.Prefix.String() + " " + strings.Join(trusted.Providers, ",")
+		if got != want[i] {
+			t.Errorf("range %d = %q, want %q", i, got, want[i])
+		}
+	}
+}
+
+func TestWriteTrustedRanges(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: ExportFormatTraefik,
+			want: `http:
+  middlewares:
+    trusted-ips:
+      ipAllowList:
+        sourceRange:
+          - "173.245.48.0/20" # cloudflare
+          - "2400:cb00::/32" # cloudflare,custom
+          - "10.0.0.0/8"
+`,
+		},
+		{
+			format: ExportFormatNginx,
+			want: `set_real_ip_from 173.245.48.0/20; # cloudflare
+set_real_ip_from 2400:cb00::/32; # cloudflare,custom
+set_real_ip_from 10.0.0.0/8;
+`,
+		},
+		{
+			format: ExportFormatIPSet,
+			want: `create traefik-real-ip-v4 hash:net family inet comment -exist
+flush traefik-real-ip-v4
+add traefik-real-ip-v4 173.245.48.0/20 comment "cloudflare" -exist
+add traefik-real-ip-v4 10.0.0.0/8 comment "" -exist
+create traefik-real-ip-v6 hash:net family inet6 comment -exist
+flush traefik-real-ip-v6
+add traefik-real-ip-v6 2400:cb00::/32 comment "cloudflare,custom" -exist
+`,
+		},
+		{
+			format: ExportFormatJSON,
+			want: `[
+  {
+    "cidr": "173.245.48.0/20",
+    "providers": [
+      "cloudflare"
+    ]
+  },
+  {
+    "cidr": "2400:cb00::/32",
+    "providers": [
+      "cloudflare",
+      "custom"
+    ]
+  },
+  {
+    "cidr": "10.0.0.0/8",
+    "providers": []
+  }
+]
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			err := WriteTrustedRanges(&buf, tt.format, exportTestRanges())
+			if err != nil {
+				t.Fatalf("WriteTrustedRanges: %v", err)
+			}
+
+			if buf.String() != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteTrustedRanges_Empty(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := WriteTrustedRanges(&buf, ExportFormatTraefik, nil)
+	if err != nil {
+		t.Fatalf("WriteTrustedRanges: %v", err)
+	}
+
+	if !strings.HasSuffix(buf.String(), "sourceRange: []\n") {
+		t.Errorf("expected an empty source range, got:\n%s", buf.String())
+	}
+
+	err = WriteTrustedRanges(&buf, "csv", nil)
+	if !errors.Is(err, ErrUnknownExportFormat) {
+		t.Errorf("expected ErrUnknownExportFormat, got %v", err)
+	}
+}
+
+func TestParseExportConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ExportConfig
+		wantErr bool
+	}{
+		{name: "disabled", config: ExportConfig{Format: "csv"}},
+		{name: "defaults", config: ExportConfig{Path: "/tmp/x", Format: "nginx"}},
+		{name: "format", config: ExportConfig{Path: "/tmp/x", Format: "csv"}, wantErr: true},
+		{name: "missing format", config: ExportConfig{Path: "/tmp/x"}, wantErr: true},
+		{
+			name:    "interval",
+			config:  ExportConfig{Path: "/tmp/x", Format: "json", Interval: "0s"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseExportConfig(tt.config)
+			if tt.wantErr != errors.Is(err, ErrInvalidExportConfig) {
+				t.Errorf("parseExportConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNew_ExportWriter(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "trusted.txt")
+	exported := filepath.Join(dir, "trusted.conf")
+
+	writeSourceFile(t, source, "10.0.0.0/24\n")
+
+	newConfiguredResolver(t, func(cfg *Config) {
+		cfg.FilePollInterval = "5ms"
+		cfg.TrustedIPs = []string{"192.0.2.0/24"}
+		cfg.TrustedIPsFile = source
+		cfg.Export = ExportConfig{Path: exported, Format: ExportFormatNginx, Interval: "5ms"}
+	})
+
+	data, err := os.ReadFile(exported)
+	if err != nil {
+		t.Fatalf("expected the export to be written at startup: %v", err)
+	}
+
+	want := "set_real_ip_from 10.0.0.0/24; # file\nset_real_ip_from 192.0.2.0/24; # custom\n"
+	if string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+
+	writeSourceFile(t, source, "10.0.1.0/24\n")
+
+	waitFor(t, func() bool {
+		data, err := os.ReadFile(exported)
+
+		return err == nil && strings.Contains(string(data), "10.0.1.0/24")
+	})
+}
+
+func TestNew_InvalidExportConfig(t *testing.T) {
+	cfg := CreateConfig()
+	cfg.ThrustLocal = false
+	cfg.ThrustCloudFlare = false
+	cfg.Export = ExportConfig{Path: filepath.Join(t.TempDir(), "out"), Format: "csv"}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	_, err := New(t.Context(), next, cfg, "test")
+	if !errors.Is(err, ErrUnknownExportFormat) {
+		t.Errorf("expected ErrUnknownExportFormat, got %v", err)
+	}
+}
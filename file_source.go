@@ -0,0 +1,341 @@
+package traefik_real_ip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrReadingTrustedIPsFile    = errors.New("error reading trusted IPs file")
+	ErrInvalidFilePollInterval  = errors.New("invalid file poll interval")
+	ErrProviderFileNotSupported = errors.New("unsupported file URL")
+)
+
+const (
+	fileSourceName          = "file"
+	defaultFilePollInterval = 10 * time.Second
+)
+
+// fileSource is a part of the trust table backed by local files. It is
+// reloaded whenever the modification stamp of its paths changes.
+type fileSource struct {
+	load  func(ctx context.Context) ([]netip.Prefix, error)
+	label string
+	stamp string
+	paths []string
+}
+
+func parseFilePollInterval(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultFilePollInterval, nil
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidFilePollInterval, value)
+	}
+
+	return interval, nil
+}
+
+// fileURLPath returns the local path of a file:// URL.
+func fileURLPath(rawURL string) (string, bool, error) {
+	if !strings.HasPrefix(strings.ToLower(rawURL), "file:") {
+		return "", false, nil
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Path == "" || (parsed.Host != "" && parsed.Host != "localhost") {
+		return "", true, fmt.Errorf("%w: %q", ErrProviderFileNotSupported, rawURL)
+	}
+
+	return parsed.Path, true, nil
+}
+
+// hasFileURL reports whether any of urls points at a local file.
+func hasFileURL(urls []string) bool {
+	for _, rawURL := range urls {
+		if _, ok, _ := fileURLPath(rawURL); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readSourceFiles reads a file, or every regular non-hidden file of a
+// directory in name order separated by newlines.
+func readSourceFiles(path string) (string, error) {
+	names, err := sourceFileNames(path)
+	if err != nil {
+		return "", err
+	}
+
+	var content strings.Builder
+
+	for i, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return "", fmt.Errorf("error reading %s: %w", name, err)
+		}
+
+		if i > 0 {
+			content.WriteString("\n")
+		}
+
+		content.Write(data)
+	}
+
+	return content.String(), nil
+}
+
+func sourceFileNames(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+
+	names := make([]string, 0, len(entries))
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		name := filepath.Join(path, entry.Name())
+
+		// Stat follows symlinks, e.g. the files of a Kubernetes ConfigMap volume.
+		entryInfo, err := os.Stat(name)
+		if err != nil || !entryInfo.Mode().IsRegular() {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// sourceStamp summarises the names, sizes and modification times of the
+// files behind paths. Unreadable paths yield a distinct stamp so that their
+// recovery triggers a reload.
+func sourceStamp(paths []string) string {
+	var stamp strings.Builder
+
+	for _, path := range paths {
+		names, err := sourceFileNames(path)
+		if err != nil {
+			stamp.WriteString(path + ":missing;")
+
+			continue
+		}
+
+		for _, name := range names {
+			info, err := os.Stat(name)
+			if err != nil {
+				stamp.WriteString(name + ":missing;")
+
+				continue
+			}
+
+			fmt.Fprintf(&stamp, "%s:%d:%d;", name, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+
+	return stamp.String()
+}
+
+func (resolver *IPResolver) readTrustedIPsFile(
+	ctx context.Context,
+	path string,
+) ([]netip.Prefix, error) {
+	content, err := readSourceFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrReadingTrustedIPsFile, err)
+	}
+
+	ips, err := resolver.parseRanges(ctx, content, fileSourceName, resolver.fileFormat)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrReadingTrustedIPsFile, path, err)
+	}
+
+	return ips, nil
+}
+
+// getProviderIPsFromFile is the file:// counterpart of getProviderIPsFromURL.
+func (resolver *IPResolver) getProviderIPsFromFile(
+	ctx context.Context,
+	providerName, rawURL, path string,
+) ([]netip.Prefix, error) {
+	body, err := readSourceFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	maxBodySize := resolver.providerSettingsFor(providerName).guards.maxBodySize
+	if maxBodySize > 0 && int64(len(body)) > maxBodySize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrResponseTooLarge, maxBodySize)
+	}
+
+	registry := resolver.providerRegistry()
+	cacheKey := resolver.urlCacheKey(providerName, rawURL)
+	previous, _ := registry.cachedURL(cacheKey)
+
+	ips, err := resolver.acceptProviderList(ctx, providerName, rawURL, body, previous.ips)
+	if err != nil {
+		return nil, err
+	}
+
+	registry.storeURL(cacheKey, urlCacheEntry{ips: ips})
+
+	return ips, nil
+}
+
+// fileSources lists the parts of the trust table that come from local files.
+func (resolver *IPResolver) fileSources() []*fileSource {
+	sources := make([]*fileSource, 0)
+
+	if resolver.conf.TrustedIPsFile != "" {
+		path := resolver.conf.TrustedIPsFile
+
+		sources = append(sources, &fileSource{
+			label: fileSourceName,
+			paths: []string{path},
+			load: func(ctx context.Context) ([]netip.Prefix, error) {
+				return resolver.readTrustedIPsFile(ctx, path)
+			},
+		})
+	}
+
+	providers := []struct {
+		provider remoteIPProvider
+		label    string
+		enabled  bool
+	}{
+		{
+			provider: cloudflareProvider,
+			label:    cloudflareSetRef,
+			enabled:  resolver.trustsSet(cloudflareSetRef, resolver.conf.ThrustCloudFlare),
+		},
+		{
+			provider: edgeOneProvider,
+			label:    edgeOneSetRef,
+			enabled:  resolver.trustsSet(edgeOneSetRef, resolver.conf.ThrustEdgeOne),
+		},
+	}
+
+	for _, entry := range providers {
+		if !entry.enabled {
+			continue
+		}
+
+		provider := resolver.providerWithURLs(entry.provider)
+
+		paths := make([]string, 0)
+
+		for _, rawURL := range provider.urls {
+			path, ok, err := fileURLPath(rawURL)
+			if ok && err == nil {
+				paths = append(paths, path)
+			}
+		}
+
+		if len(paths) == 0 {
+			continue
+		}
+
+		sources = append(sources, &fileSource{
+			label: entry.label,
+			paths: paths,
+			load: func(ctx context.Context) ([]netip.Prefix, error) {
+				ips, _ := resolver.fetchProviderIPs(ctx, provider)
+
+				return ips, nil
+			},
+		})
+	}
+
+	return sources
+}
+
+// watchFileSources polls the file backed sources until ctx is done.
+func (resolver *IPResolver) watchFileSources(ctx context.Context) {
+	if resolver.filePollInterval <= 0 {
+		return
+	}
+
+	sources := resolver.fileSources()
+	if len(sources) == 0 {
+		return
+	}
+
+	for _, source := range sources {
+		source.stamp = sourceStamp(source.paths)
+	}
+
+	go func() {
+		ticker := time.NewTicker(resolver.filePollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for _, source := range sources {
+					resolver.reloadFileSource(ctx, source)
+				}
+			}
+		}
+	}()
+}
+
+func (resolver *IPResolver) reloadFileSource(ctx context.Context, source *fileSource) {
+	stamp := sourceStamp(source.paths)
+	if stamp == source.stamp {
+		return
+	}
+
+	source.stamp = stamp
+
+	ips, err := source.load(ctx)
+	if err != nil {
+		resolver.logger.ErrorContext(
+			ctx,
+			"Error reloading trusted IPs, keeping the previous ranges",
+			slog.String("source", source.label),
+			slog.Any("error", err),
+		)
+
+		return
+	}
+
+	resolver.setTrustedSources(map[string][]netip.Prefix{source.label: ips})
+
+	resolver.logger.InfoContext(
+		ctx,
+		"Reloaded trusted IPs",
+		slog.String("source", source.label),
+		slog.Int("count", len(ips)),
+	)
+}
@@ -0,0 +1,228 @@
+package traefik_real_ip
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeSourceFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadSourceFiles_Directory(t *testing.T) {
+	dir := t.TempDir()
+
+	writeSourceFile(t, filepath.Join(dir, "b.txt"), "10.0.1.0/24")
+	writeSourceFile(t, filepath.Join(dir, "a.txt"), "10.0.0.0/24\n")
+	writeSourceFile(t, filepath.Join(dir, ".hidden"), "10.0.2.0/24\n")
+
+	err := os.Mkdir(filepath.Join(dir, "nested"), 0o700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := readSourceFiles(dir)
+	if err != nil {
+		t.Fatalf("readSourceFiles: %v", err)
+	}
+
+	want := "10.0.0.0/24\n\n10.0.1.0/24"
+	if content != want {
+		t.Errorf("readSourceFiles() = %q, want %q", content, want)
+	}
+}
+
+func TestFileURLPath(t *testing.T) {
+	tests := []struct {
+		url     string
+		path    string
+		isFile  bool
+		wantErr bool
+	}{
+		{url: "https://www.cloudflare.com/ips-v4"},
+		{url: "file:///srv/ips.txt", path: "/srv/ips.txt", isFile: true},
+		{url: "file://localhost/srv/ips.txt", path: "/srv/ips.txt", isFile: true},
+		{url: "file://fileserver/srv/ips.txt", isFile: true, wantErr: true},
+		{url: "file://", isFile: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		path, isFile, err := fileURLPath(tt.url)
+		if path != tt.path || isFile != tt.isFile || tt.wantErr != (err != nil) {
+			t.Errorf("fileURLPath(%q) = %q, %v, %v", tt.url, path, isFile, err)
+		}
+	}
+}
+
+func TestParseFilePollInterval(t *testing.T) {
+	interval, err := parseFilePollInterval("")
+	if err != nil || interval != defaultFilePollInterval {
+		t.Errorf("expected the default interval, got %v, %v", interval, err)
+	}
+
+	for _, value := range []string{"often", "-1s"} {
+		_, err := parseFilePollInterval(value)
+		if !errors.Is(err, ErrInvalidFilePollInterval) {
+			t.Errorf("%q: expected ErrInvalidFilePollInterval, got %v", value, err)
+		}
+	}
+}
+
+func TestNew_TrustedIPsFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.txt")
+	writeSourceFile(t, invalid, "not a cidr\n")
+
+	for _, path := range []string{invalid, filepath.Join(dir, "missing.txt")} {
+		cfg := CreateConfig()
+		cfg.ThrustLocal = false
+		cfg.ThrustCloudFlare = false
+		cfg.TrustedIPsFile = path
+
+		next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+		_, err := New(t.Context(), next, cfg, "test")
+		if !errors.Is(err, ErrReadingTrustedIPsFile) {
+			t.Errorf("%s: expected ErrReadingTrustedIPsFile, got %v", path, err)
+		}
+	}
+}
+
+func TestNew_TrustedIPsFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trusted.txt")
+	writeSourceFile(t, path, "# proxies\n10.0.0.0/24\n")
+
+	resolver := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.FilePollInterval = "5ms"
+		cfg.TrustedIPs = []string{"192.0.2.0/24"}
+		cfg.TrustedIPsFile = path
+	})
+
+	ctx := t.Context()
+	oldProxy := netip.MustParseAddr("10.0.0.1")
+	newProxy := netip.MustParseAddr("10.0.1.1")
+	custom := netip.MustParseAddr("192.0.2.1")
+
+	if !resolver.isTrustedIP(ctx, oldProxy) || resolver.isTrustedIP(ctx, newProxy) {
+		t.Fatal("expected the initial file ranges to be trusted")
+	}
+
+	writeSourceFile(t, path, "10.0.1.0/24\n10.0.2.0/24\n")
+
+	waitFor(t, func() bool { return resolver.isTrustedIP(ctx, newProxy) })
+
+	if resolver.isTrustedIP(ctx, oldProxy) {
+		t.Error("expected the removed range to be untrusted")
+	}
+
+	if !resolver.isTrustedIP(ctx, custom) {
+		t.Error("expected the inline trustedIPs to be kept")
+	}
+
+	// A broken file keeps the previous ranges.
+	writeSourceFile(t, path, "garbage\n")
+
+	resolver.reloadFileSource(ctx, resolver.fileSources()[0])
+
+	if !resolver.isTrustedIP(ctx, newProxy) {
+		t.Error("expected the previous ranges to be kept")
+	}
+}
+
+func TestIPResolver_CloseStopsFilePolling(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trusted.txt")
+	writeSourceFile(t, path, "10.0.0.0/24\n")
+
+	resolver := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.FilePollInterval = "5ms"
+		cfg.TrustedIPsFile = path
+	})
+
+	if err := resolver.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	writeSourceFile(t, path, "10.0.1.0/24\n10.0.2.0/24\n")
+
+	// Give a running poller several intervals to pick the change up.
+	<-time.After(50 * time.Millisecond)
+
+	if resolver.isTrustedIP(t.Context(), netip.MustParseAddr("10.0.1.1")) {
+		t.Error("expected the closed instance to stop reloading the file")
+	}
+}
+
+func TestNew_FileProviderURLReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cloudflare.txt")
+	writeSourceFile(t, path, "173.245.48.0/20\n")
+
+	resolver := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.FilePollInterval = "5ms"
+		cfg.ThrustCloudFlare = true
+		cfg.CloudFlare.URLs = []string{"file://" + path}
+	})
+
+	ctx := t.Context()
+
+	if !resolver.isTrustedIP(ctx, netip.MustParseAddr("173.245.48.1")) {
+		t.Fatal("expected the file provider ranges to be trusted")
+	}
+
+	writeSourceFile(t, path, "173.245.48.0/20\n103.21.244.0/22\n")
+
+	waitFor(t, func() bool {
+		trusted, provider := resolver.matchTrustedIP(ctx, netip.MustParseAddr("103.21.244.1"))
+
+		return trusted && provider == "cloudflare"
+	})
+
+	// An instance created after the reload must not get the stale list.
+	later := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.ThrustCloudFlare = true
+		cfg.CloudFlare.URLs = []string{"file://" + path}
+	})
+
+	if !later.isTrustedIP(ctx, netip.MustParseAddr("103.21.244.1")) {
+		t.Error("expected a later instance to read the changed file")
+	}
+}
+
+func TestGetProviderIPsFromURL_SignedFile(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ips.txt")
+	writeSourceFile(t, path, signedList)
+
+	resolver := newSigningResolver(t, public)
+
+	_, err = resolver.getProviderIPsFromURL(t.Context(), "test", "file://"+path)
+	if !errors.Is(err, ErrMissingSignature) {
+		t.Fatalf("expected ErrMissingSignature, got %v", err)
+	}
+
+	writeSourceFile(t, path+signatureSuffix, string(ed25519.Sign(private, []byte(signedList))))
+
+	ips, err := resolver.getProviderIPsFromURL(t.Context(), "test", "file://"+path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(ips) != 2 {
+		t.Errorf("expected 2 ranges, got %v", ips)
+	}
+}
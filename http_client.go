@@ -0,0 +1,263 @@
+package traefik_real_ip
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidHTTPClientConfig = errors.New("invalid HTTP client configuration")
+
+// HTTPClientConfig holds the settings of the HTTP client used to fetch
+// remote provider lists. Certificates and keys are given either as PEM
+// content or as file paths.
+type HTTPClientConfig struct {
+	Headers            map[string]string `json:"headers,omitempty"`
+	Proxy              string            `json:"proxy,omitempty"`
+	Timeout            string            `json:"timeout,omitempty"`
+	UserAgent          string            `json:"userAgent,omitempty"`
+	ClientCert         string            `json:"clientCert,omitempty"`
+	ClientKey          string            `json:"clientKey,omitempty"`
+	RootCAs            []string          `json:"rootCAs,omitempty"`
+	DisableCompression bool              `json:"disableCompression,omitempty"`
+}
+
+// providerHTTPClient is the validated form of an HTTPClientConfig. The
+// fingerprint identifies the configuration, see providerVariant.
+type providerHTTPClient struct {
+	client      *http.Client
+	headers     http.Header
+	fingerprint string
+}
+
+var defaultProviderHTTPClient = &providerHTTPClient{
+	client:  &http.Client{Timeout: defaultRemoteProviderTimeout},
+	headers: http.Header{},
+}
+
+func newProviderHTTPClient(config HTTPClientConfig) (*providerHTTPClient, error) {
+	timeout := defaultRemoteProviderTimeout
+
+	if config.Timeout != "" {
+		parsed, err := time.ParseDuration(config.Timeout)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("%w: timeout: %q", ErrInvalidHTTPClientConfig, config.Timeout)
+		}
+
+		timeout = parsed
+	}
+
+	headers := http.Header{}
+	for key, value := range config.Headers {
+		headers.Set(key, value)
+	}
+
+	if config.UserAgent != "" {
+		headers.Set("User-Agent", config.UserAgent)
+	}
+
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHTTPClientConfig, err)
+	}
+
+	fingerprint := sha256.Sum256(encoded)
+
+	transport, err := sharedProviderTransport(config, fingerprint[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &providerHTTPClient{
+		client:      &http.Client{Timeout: timeout, Transport: transport},
+		headers:     headers,
+		fingerprint: hex.EncodeToString(fingerprint[:]),
+	}, nil
+}
+
+// providerTransports holds one transport, and so one connection pool, per
+// client configuration. Traefik never closes an instance, so a transport per
+// instance would leave another pool behind on every configuration reload.
+var providerTransports = struct {
+	mu    sync.Mutex
+	byKey map[string]*http.Transport
+}{byKey: make(map[string]*http.Transport)}
+
+// sharedProviderTransport returns the transport of config, creating it on
+// first use. Certificates named by path are part of the key, so a rotated
+// file gets a new transport on the next reload.
+func sharedProviderTransport(config HTTPClientConfig, fingerprint []byte) (*http.Transport, error) {
+	digest := sha256.New()
+	digest.Write(fingerprint)
+
+	for _, value := range append([]string{config.ClientCert, config.ClientKey}, config.RootCAs...) {
+		if value == "" {
+			continue
+		}
+
+		// Unreadable files are reported by newProviderTransport below.
+		data, err := readPEM(value)
+		if err == nil {
+			digest.Write(data)
+		}
+	}
+
+	key := hex.EncodeToString(digest.Sum(nil))
+
+	providerTransports.mu.Lock()
+	defer providerTransports.mu.Unlock()
+
+	if transport, ok := providerTransports.byKey[key]; ok {
+		return transport, nil
+	}
+
+	transport, err := newProviderTransport(config)
+	if err != nil {
+		return nil, err
+	}
+
+	providerTransports.byKey[key] = transport
+
+	return transport, nil
+}
+
+func newProviderTransport(config HTTPClientConfig) (*http.Transport, error) {
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		transport = &http.Transport{}
+	} else {
+		transport = transport.Clone()
+	}
+
+	transport.DisableCompression = config.DisableCompression
+
+	if config.Proxy != "" {
+		proxyURL, err := url.Parse(config.Proxy)
+		if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("%w: proxy: %q", ErrInvalidHTTPClientConfig, redactURL(config.Proxy))
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig, err := newProviderTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	return transport, nil
+}
+
+func newProviderTLSConfig(config HTTPClientConfig) (*tls.Config, error) {
+	if len(config.RootCAs) == 0 && config.ClientCert == "" && config.ClientKey == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if len(config.RootCAs) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		for _, rootCA := range config.RootCAs {
+			data, err := readPEM(rootCA)
+			if err != nil {
+				return nil, fmt.Errorf("%w: rootCAs: %w", ErrInvalidHTTPClientConfig, err)
+			}
+
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("%w: rootCAs: no certificate found in %q",
+					ErrInvalidHTTPClientConfig, pemSource(rootCA))
+			}
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.ClientCert != "" || config.ClientKey != "" {
+		if config.ClientCert == "" || config.ClientKey == "" {
+			return nil, fmt.Errorf("%w: clientCert and clientKey must be set together",
+				ErrInvalidHTTPClientConfig)
+		}
+
+		certPEM, err := readPEM(config.ClientCert)
+		if err != nil {
+			return nil, fmt.Errorf("%w: clientCert: %w", ErrInvalidHTTPClientConfig, err)
+		}
+
+		keyPEM, err := readPEM(config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w: clientKey: %w", ErrInvalidHTTPClientConfig, err)
+		}
+
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("%w: client certificate: %w", ErrInvalidHTTPClientConfig, err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// readPEM returns value itself when it holds PEM content, or the content of
+// the file it names.
+func readPEM(value string) ([]byte, error) {
+	if strings.Contains(value, "-----BEGIN") {
+		return []byte(value), nil
+	}
+
+	data, err := os.ReadFile(value)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", value, err)
+	}
+
+	return data, nil
+}
+
+// pemSource describes a PEM value in error messages without echoing its
+// content.
+func pemSource(value string) string {
+	if strings.Contains(value, "-----BEGIN") {
+		return "inline PEM"
+	}
+
+	return value
+}
+
+// redactURL hides the password of a URL such as an authenticated proxy.
+func redactURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "<unparsable URL>"
+	}
+
+	return parsed.Redacted()
+}
+
+// providerHTTPClient returns the client configured for this instance, or the
+// default one.
+func (resolver *IPResolver) providerHTTPClient() *providerHTTPClient {
+	if resolver.httpClient == nil {
+		return defaultProviderHTTPClient
+	}
+
+	return resolver.httpClient
+}
@@ -0,0 +1,270 @@
+package traefik_real_ip
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const clientTestList = "173.245.48.0/20\n"
+
+func serveClientTestList(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(clientTestList))
+}
+
+func newClientTestResolver(t *testing.T, config HTTPClientConfig) *IPResolver {
+	t.Helper()
+
+	client, err := newProviderHTTPClient(config)
+	if err != nil {
+		t.Fatalf("newProviderHTTPClient: %v", err)
+	}
+
+	resolver := newTestResolver(t)
+	resolver.httpClient = client
+
+	return resolver
+}
+
+func serverCertificatePEM(server *httptest.Server) string {
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}))
+}
+
+func TestNewProviderHTTPClient_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config HTTPClientConfig
+	}{
+		{name: "timeout", config: HTTPClientConfig{Timeout: "soon"}},
+		{name: "zero timeout", config: HTTPClientConfig{Timeout: "0s"}},
+		{name: "proxy", config: HTTPClientConfig{Proxy: "proxy.internal:3128"}},
+		{name: "missing CA file", config: HTTPClientConfig{RootCAs: []string{"/does/not/exist.pem"}}},
+		{
+			name:   "CA without certificate",
+			config: HTTPClientConfig{RootCAs: []string{"-----BEGIN CERTIFICATE-----\n"}},
+		},
+		{name: "certificate without key", config: HTTPClientConfig{ClientCert: "/tmp/cert.pem"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newProviderHTTPClient(tt.config)
+			if !errors.Is(err, ErrInvalidHTTPClientConfig) {
+				t.Errorf("expected ErrInvalidHTTPClientConfig, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNewProviderHTTPClient_RedactsProxyPassword(t *testing.T) {
+	_, err := newProviderHTTPClient(HTTPClientConfig{Proxy: "http://user:secret@"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("expected the password to be redacted, got %q", err)
+	}
+}
+
+func TestNewProviderHTTPClient_SharesTransports(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(serveClientTestList))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	writeSourceFile(t, caFile, serverCertificatePEM(server))
+
+	transport := func(config HTTPClientConfig) http.RoundTripper {
+		t.Helper()
+
+		client, err := newProviderHTTPClient(config)
+		if err != nil {
+			t.Fatalf("newProviderHTTPClient: %v", err)
+		}
+
+		return client.client.Transport
+	}
+
+	config := HTTPClientConfig{RootCAs: []string{caFile}}
+	shared := transport(config)
+
+	if transport(config) != shared {
+		t.Error("expected the same configuration to share its transport")
+	}
+
+	if transport(HTTPClientConfig{RootCAs: []string{caFile}, DisableCompression: true}) == shared {
+		t.Error("expected a different configuration to get its own transport")
+	}
+
+	// A rewritten certificate file yields a new transport.
+	writeSourceFile(t, caFile, "# rotated\n"+serverCertificatePEM(server))
+
+	if transport(config) == shared {
+		t.Error("expected a changed certificate file to get a new transport")
+	}
+}
+
+func TestGetProviderIPsFromURL_UserAgentAndHeaders(t *testing.T) {
+	var userAgent, token string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		token = r.Header.Get("X-Api-Token")
+
+		serveClientTestList(w, r)
+	}))
+	defer server.Close()
+
+	resolver := newClientTestResolver(t, HTTPClientConfig{
+		UserAgent: "traefik-real-ip/test",
+		Headers:   map[string]string{"x-api-token": "abc"},
+	})
+
+	_, err := resolver.getProviderIPsFromURL(t.Context(), "test", server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if userAgent != "traefik-real-ip/test" || token != "abc" {
+		t.Errorf("expected configured headers, got User-Agent %q and token %q", userAgent, token)
+	}
+}
+
+func TestGetProviderIPsFromURL_Proxy(t *testing.T) {
+	var host, auth string
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.URL.Host
+		auth = r.Header.Get("Proxy-Authorization")
+
+		serveClientTestList(w, r)
+	}))
+	defer proxy.Close()
+
+	proxyURL := "http://user:pass@" + proxy.Listener.Addr().String()
+	resolver := newClientTestResolver(t, HTTPClientConfig{Proxy: proxyURL})
+
+	ips, err := resolver.getProviderIPsFromURL(t.Context(), "test", "http://provider.invalid/ips")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(ips) != 1 || host != "provider.invalid" {
+		t.Errorf("expected the request to go through the proxy, got %v for host %q", ips, host)
+	}
+
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass"))
+	if auth != want {
+		t.Errorf("expected proxy credentials %q, got %q", want, auth)
+	}
+}
+
+func TestGetProviderIPsFromURL_RootCAs(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(serveClientTestList))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+
+	err := os.WriteFile(caFile, []byte(serverCertificatePEM(server)), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		rootCAs []string
+		wantErr bool
+	}{
+		{name: "untrusted", wantErr: true},
+		{name: "inline PEM", rootCAs: []string{serverCertificatePEM(server)}},
+		{name: "file", rootCAs: []string{caFile}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := newClientTestResolver(t, HTTPClientConfig{RootCAs: tt.rootCAs})
+
+			_, err := resolver.getProviderIPsFromURL(t.Context(), "test", server.URL)
+			if tt.wantErr != (err != nil) {
+				t.Errorf("getProviderIPsFromURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetProviderIPsFromURL_ClientCertificate(t *testing.T) {
+	var presented int
+
+	server := httptest.NewUnstartedServer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			presented = len(r.TLS.PeerCertificates)
+
+			serveClientTestList(w, r)
+		}),
+	)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert, MinVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	// Reuse the server certificate as the client certificate.
+	key, err := x509.MarshalPKCS8PrivateKey(server.TLS.Certificates[0].PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := newClientTestResolver(t, HTTPClientConfig{
+		RootCAs:    []string{serverCertificatePEM(server)},
+		ClientCert: serverCertificatePEM(server),
+		ClientKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})),
+	})
+
+	_, err = resolver.getProviderIPsFromURL(t.Context(), "test", server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if presented != 1 {
+		t.Errorf("expected a client certificate, got %d", presented)
+	}
+}
+
+func TestGetProviderIPs_MirrorURLs(t *testing.T) {
+	mirror := httptest.NewServer(http.HandlerFunc(serveClientTestList))
+	defer mirror.Close()
+
+	settings, err := parseProviderConfig("test", ProviderConfig{URLs: []string{mirror.URL}})
+	if err != nil {
+		t.Fatalf("parseProviderConfig: %v", err)
+	}
+
+	resolver := newTestResolver(t)
+	resolver.providerSettings["test"] = settings
+
+	provider := remoteIPProvider{name: "test", urls: []string{"http://provider.invalid/ips"}}
+
+	ips := resolver.getProviderIPs(t.Context(), provider)
+	if len(ips) != 1 {
+		t.Errorf("expected ranges from the mirror, got %v", ips)
+	}
+}
+
+func TestParseProviderConfig_InvalidURLs(t *testing.T) {
+	for _, rawURL := range []string{"ftp://mirror/ips", "mirror/ips", "https://"} {
+		_, err := parseProviderConfig("test", ProviderConfig{URLs: []string{rawURL}})
+		if !errors.Is(err, ErrInvalidProviderConfig) {
+			t.Errorf("%q: expected ErrInvalidProviderConfig, got %v", rawURL, err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package traefik_real_ip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+var ErrInvalidHeaderPolicy = errors.New("invalid onInvalidHeader policy")
+
+// invalidHeaderErrors are the errors reported for a malformed or duplicated
+// client IP header.
+var invalidHeaderErrors = []error{
+	ErrInvalidIPFormat,
+	ErrCfConnectingIPInvalid,
+	ErrEoConnectingIPInvalid,
+	ErrXRealIPInvalid,
+	ErrXForwardedForInvalid,
+}
+
+// invalidHeaderCounters counts how often each onInvalidHeader policy was
+// applied. The running count is logged with every invalid header.
+type invalidHeaderCounters struct {
+	rejected atomic.Uint64
+	ignored  atomic.Uint64
+	source   atomic.Uint64
+}
+
+func (counters *invalidHeaderCounters) add(policy string) uint64 {
+	switch policy {
+	case InvalidHeaderPolicyIgnore:
+		return counters.ignored.Add(1)
+	case InvalidHeaderPolicySource:
+		return counters.source.Add(1)
+	default:
+		return counters.rejected.Add(1)
+	}
+}
+
+func parseInvalidHeaderPolicy(policy string, status int) (string, int, error) {
+	normalized := strings.ToLower(strings.TrimSpace(policy))
+
+	switch normalized {
+	case "":
+		normalized = InvalidHeaderPolicyReject
+	case InvalidHeaderPolicyReject, InvalidHeaderPolicyIgnore, InvalidHeaderPolicySource:
+	default:
+		return "", 0, fmt.Errorf("%w: %s", ErrInvalidHeaderPolicy, policy)
+	}
+
+	if status == 0 {
+		status = http.StatusBadRequest
+	}
+
+	if status < 400 || status > 599 {
+		return "", 0, fmt.Errorf(
+			"%w: status %d is not an error status", ErrInvalidHeaderPolicy, status,
+		)
+	}
+
+	return normalized, status, nil
+}
+
+func isInvalidHeaderError(err error) bool {
+	for _, target := range invalidHeaderErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// settleHeader applies the onInvalidHeader policy when reading header failed
+// and the private client policy otherwise. It returns the address to use and
+// whether the search is over.
+func (resolver *IPResolver) settleHeader(
+	ctx context.Context,
+	header string,
+	ip netip.Addr,
+	err error,
+	srcIP netip.Addr,
+) (netip.Addr, bool, error) {
+	if err == nil {
+		return resolver.applyPrivateClientPolicy(ctx, header, ip, srcIP)
+	}
+
+	if !isInvalidHeaderError(err) {
+		return netip.Addr{}, true, err
+	}
+
+	count := resolver.invalidHeaders.add(resolver.onInvalidHeader)
+
+	resolver.logger.WarnContext(
+		ctx,
+		"Invalid client IP header",
+		slog.String("header", header),
+		slog.String("policy", resolver.onInvalidHeader),
+		slog.Uint64("count", count),
+		slog.Any("error", err),
+	)
+
+	switch resolver.onInvalidHeader {
+	case InvalidHeaderPolicyIgnore:
+		return netip.Addr{}, false, nil
+	case InvalidHeaderPolicySource:
+		return srcIP, true, nil
+	default:
+		return netip.Addr{}, true, err
+	}
+}
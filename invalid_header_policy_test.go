@@ -0,0 +1,136 @@
+package traefik_real_ip
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseInvalidHeaderPolicy(t *testing.T) {
+	policy, status, err := parseInvalidHeaderPolicy("", 0)
+	if err != nil || policy != InvalidHeaderPolicyReject || status != http.StatusBadRequest {
+		t.Errorf("expected reject with 400, got %q %d, %v", policy, status, err)
+	}
+
+	policy, status, err = parseInvalidHeaderPolicy(" Ignore ", 422)
+	if err != nil || policy != InvalidHeaderPolicyIgnore || status != 422 {
+		t.Errorf("expected ignore with 422, got %q %d, %v", policy, status, err)
+	}
+
+	for _, config := range []struct {
+		policy string
+		status int
+	}{
+		{policy: "drop"},
+		{policy: InvalidHeaderPolicyReject, status: http.StatusOK},
+		{policy: InvalidHeaderPolicyReject, status: 600},
+	} {
+		_, _, err := parseInvalidHeaderPolicy(config.policy, config.status)
+		if !errors.Is(err, ErrInvalidHeaderPolicy) {
+			t.Errorf("%+v: expected ErrInvalidHeaderPolicy, got %v", config, err)
+		}
+	}
+}
+
+func invalidHeaderPolicy(policy string, status int) func(*Config) {
+	return func(cfg *Config) {
+		cfg.TrustedIPs = []string{"10.0.0.0/8"}
+		cfg.OnInvalidHeader = policy
+		cfg.InvalidHeaderStatus = status
+	}
+}
+
+func TestIPResolver_OnInvalidHeader(t *testing.T) {
+	tests := []struct {
+		name           string
+		policy         string
+		status         int
+		headers        [][2]string
+		expectedStatus int
+		expectedIP     string
+	}{
+		{
+			name:           "Reject with the configured status",
+			policy:         InvalidHeaderPolicyReject,
+			status:         http.StatusUnprocessableEntity,
+			headers:        [][2]string{{CfConnectingIP, "invalid"}},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Ignore a malformed provider header",
+			policy:         InvalidHeaderPolicyIgnore,
+			headers:        [][2]string{{CfConnectingIP, "invalid"}, {XRealIP, "9.9.9.9"}},
+			expectedStatus: http.StatusOK,
+			expectedIP:     "9.9.9.9",
+		},
+		{
+			name:           "Ignore a duplicate X-Real-IP",
+			policy:         InvalidHeaderPolicyIgnore,
+			headers:        [][2]string{{XRealIP, "9.9.9.9"}, {XRealIP, "8.8.8.8"}},
+			expectedStatus: http.StatusOK,
+			expectedIP:     "10.0.0.1",
+		},
+		{
+			name:           "Source IP on a malformed X-Forwarded-For",
+			policy:         InvalidHeaderPolicySource,
+			headers:        [][2]string{{XForwardedFor, "invalid"}},
+			expectedStatus: http.StatusOK,
+			expectedIP:     "10.0.0.1",
+		},
+		{
+			name:           "Source IP on a malformed X-Real-IP",
+			policy:         InvalidHeaderPolicySource,
+			headers:        [][2]string{{XRealIP, "invalid"}, {XForwardedFor, "9.9.9.9"}},
+			expectedStatus: http.StatusOK,
+			expectedIP:     "10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := newConfiguredResolver(t, invalidHeaderPolicy(tt.policy, tt.status))
+
+			req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			req.RemoteAddr = "10.0.0.1:1234"
+
+			for _, header := range tt.headers {
+				req.Header.Add(header[0], header[1])
+			}
+
+			rec := httptest.NewRecorder()
+			resolver.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+
+			if tt.expectedIP != "" && req.Header.Get(XRealIP) != tt.expectedIP {
+				t.Errorf("expected %s, got %s", tt.expectedIP, req.Header.Get(XRealIP))
+			}
+		})
+	}
+}
+
+func TestIPResolver_InvalidHeaderCounters(t *testing.T) {
+	resolver := newConfiguredResolver(t, invalidHeaderPolicy(InvalidHeaderPolicyIgnore, 0))
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set(EoConnectingIP, "invalid")
+		req.Header.Set(XForwardedFor, "invalid")
+
+		resolver.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	counters := &resolver.invalidHeaders
+	rejected := counters.rejected.Load()
+	ignored := counters.ignored.Load()
+	source := counters.source.Load()
+
+	if rejected != 0 || ignored != 4 || source != 0 {
+		t.Errorf("expected 4 ignored headers, got rejected=%d ignored=%d source=%d",
+			rejected, ignored, source)
+	}
+}
@@ -2,26 +2,169 @@ package traefik_real_ip
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
-	"net"
+	"net/netip"
+	"sort"
+	"strings"
 )
 
-func (resolver *IPResolver) isTrustedIP(ctx context.Context, ip net.IP) bool {
-	for _, ipNet := range resolver.trustedIPNets {
-		if ipNet.Contains(ip) {
-			return true
+func (resolver *IPResolver) isTrustedIP(ctx context.Context, ip netip.Addr) bool {
+	trusted, _ := resolver.matchTrustedIP(ctx, ip)
+
+	return trusted
+}
+
+// matchTrustedIP reports whether ip is trusted and, if so, the label of the
+// provider whose range matched. Decisions are memoised in the trust cache
+// when one is configured.
+func (resolver *IPResolver) matchTrustedIP(ctx context.Context, ip netip.Addr) (bool, string) {
+	ip = ip.Unmap()
+
+	resolver.refreshTimedEntriesIfDue(ctx)
+
+	decision, ok := resolver.trustCache.get(ip)
+	if ok {
+		return decision.trusted, decision.provider
+	}
+
+	decision = resolver.evaluateTrust(ctx, ip)
+
+	return decision.trusted, decision.provider
+}
+
+// evaluateTrust looks ip up in the trust table and caches the decision. The
+// decision is cached under the read lock so that a table swap, which purges
+// the cache under the write lock, cannot be followed by a stale entry.
+func (resolver *IPResolver) evaluateTrust(ctx context.Context, ip netip.Addr) trustDecision {
+	resolver.trustMu.RLock()
+	defer resolver.trustMu.RUnlock()
+
+	decision := trustDecision{}
+
+	for _, prefix := range resolver.trustedIPNets {
+		if prefix.Contains(ip) {
+			decision = trustDecision{trusted: true, provider: resolver.trustedIPProviders[prefix]}
+
+			break
+		}
+	}
+
+	if !decision.trusted {
+		resolver.logger.DebugContext(ctx, "IP is not trusted", slog.String("ip", ip.String()))
+	}
+
+	resolver.trustCache.add(ip, decision)
+
+	return decision
+}
+
+// setTrustedSources replaces the ranges of the given sources, keeping those of
+// every other source, and rebuilds the trust table from the result.
+func (resolver *IPResolver) setTrustedSources(sources map[string][]netip.Prefix) {
+	resolver.trustMu.Lock()
+
+	if resolver.trustSources == nil {
+		resolver.trustSources = make(map[string][]netip.Prefix, len(sources))
+	}
+
+	for label, prefixes := range sources {
+		resolver.trustSources[label] = prefixes
+	}
+
+	labels := make([]string, 0, len(resolver.trustSources))
+	for label := range resolver.trustSources {
+		labels = append(labels, label)
+	}
+
+	sort.Strings(labels)
+
+	prefixes := make([]netip.Prefix, 0)
+	prefixLabels := make(map[netip.Prefix][]string)
+
+	for _, label := range labels {
+		for _, prefix := range resolver.trustSources[label] {
+			prefixes = append(prefixes, prefix)
+			prefixLabels[prefix] = append(prefixLabels[prefix], label)
 		}
 	}
 
-	resolver.logger.DebugContext(ctx, "IP is not trusted", slog.String("ip", ip.String()))
+	prefixes, prefixLabels = excludePrefixes(prefixes, prefixLabels, resolver.untrustedIPNets)
+	prefixes, providers := aggregatePrefixes(prefixes, prefixLabels)
+
+	resolver.trustedIPNets = prefixes
+	resolver.trustedIPProviders = providers
+	resolver.trustCache.purge()
+	resolver.trustMu.Unlock()
+
+	if resolver.trustCache != nil {
+		hits, misses := resolver.trustCache.stats()
+		resolver.logger.Debug(
+			"Trust table updated, cache purged",
+			slog.Int("count", len(prefixes)),
+			slog.Uint64("cacheHits", hits),
+			slog.Uint64("cacheMisses", misses),
+		)
+	}
+}
+
+// isPrivateIP reports whether ip is in one of the enabled non-public ranges
+// and therefore cannot be a client's real address.
+func (resolver *IPResolver) isPrivateIP(ip netip.Addr) bool {
+	_, ok := resolver.nonPublicCategory(ip)
+
+	return ok
+}
+
+// nonPublicCategory returns the category of the non-public range containing
+// ip, if any.
+func (resolver *IPResolver) nonPublicCategory(ip netip.Addr) (string, bool) {
+	table := resolver.nonPublic
+	if table == nil {
+		table = defaultNonPublicTable
+	}
+
+	return table.category(ip)
+}
+
+// parseIP parses a textual IP address and normalises IPv4-mapped IPv6
+// addresses to their IPv4 form so they match IPv4 prefixes consistently.
+// Zoned addresses are rejected, mirroring net.ParseIP.
+func parseIP(value string) (netip.Addr, error) {
+	ip, err := netip.ParseAddr(strings.TrimSpace(value))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%w: %s", ErrInvalidIPFormat, value)
+	}
 
-	return false
+	if ip.Zone() != "" {
+		return netip.Addr{}, fmt.Errorf("%w: %s", ErrInvalidIPFormat, value)
+	}
+
+	return ip.Unmap(), nil
 }
 
-func (resolver *IPResolver) isPrivateIP(ip net.IP) bool {
-	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalMulticast() || ip.IsLinkLocalUnicast() {
-		return true
+// parsePrefix parses a CIDR block, masks its host bits and normalises
+// IPv4-mapped IPv6 prefixes to their IPv4 form.
+func parsePrefix(value string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(strings.TrimSpace(value))
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid prefix: %w", err)
+	}
+
+	return normalizePrefix(prefix), nil
+}
+
+func normalizePrefix(prefix netip.Prefix) netip.Prefix {
+	addr := prefix.Addr()
+	bits := prefix.Bits()
+
+	if addr.Is4In6() {
+		if bits < 96 {
+			return prefix.Masked()
+		}
+
+		return netip.PrefixFrom(addr.Unmap(), bits-96).Masked()
 	}
 
-	return false
+	return prefix.Masked()
 }
@@ -7,6 +7,7 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
 	"strings"
 )
 
@@ -21,9 +22,9 @@ var (
 
 func (resolver *IPResolver) getRealIP(
 	ctx context.Context,
-	srcIP net.IP,
+	srcIP netip.Addr,
 	req *http.Request,
-) (net.IP, error) {
+) (netip.Addr, error) {
 	if !resolver.isTrustedIP(ctx, srcIP) {
 		resolver.logger.DebugContext(
 			ctx,
@@ -48,11 +49,10 @@ func (resolver *IPResolver) getRealIP(
 
 	if len(cfConnectingIPHeader) > 0 {
 		cfIP, err := resolver.handleCFIP(ctx, req)
-		if err != nil {
-			return nil, err
+		ip, done, err := resolver.settleHeader(ctx, CfConnectingIP, cfIP, err, srcIP)
+		if done {
+			return ip, err
 		}
-
-		return cfIP, nil
 	}
 
 	eoConnectingIPHeader := req.Header.Values(EoConnectingIP)
@@ -65,11 +65,10 @@ func (resolver *IPResolver) getRealIP(
 
 	if len(eoConnectingIPHeader) > 0 {
 		eoIP, err := resolver.handleEOIP(ctx, req)
-		if err != nil {
-			return nil, err
+		ip, done, err := resolver.settleHeader(ctx, EoConnectingIP, eoIP, err, srcIP)
+		if done {
+			return ip, err
 		}
-
-		return eoIP, nil
 	}
 
 	xRealIPHeader := req.Header.Values(XRealIP)
@@ -82,19 +81,10 @@ func (resolver *IPResolver) getRealIP(
 
 	if len(xRealIPHeader) > 0 {
 		xRealIP, err := resolver.handleXRealIP(ctx, req)
-		if err != nil {
-			return nil, err
+		ip, done, err := resolver.settleHeader(ctx, XRealIP, xRealIP, err, srcIP)
+		if done {
+			return ip, err
 		}
-
-		if !resolver.isPrivateIP(xRealIP) {
-			return xRealIP, nil
-		}
-
-		resolver.logger.DebugContext(
-			ctx,
-			"X-Real-IP is resolved to a private IP, skipping",
-			slog.String("ip", xRealIP.String()),
-		)
 	}
 
 	xForwardedForHeader := req.Header.Values(XForwardedFor)
@@ -106,12 +96,15 @@ func (resolver *IPResolver) getRealIP(
 	)
 
 	if len(xForwardedForHeader) > 0 {
-		xForwardedFor, err := resolver.handleXForwardedFor(ctx, req)
-		if err != nil {
-			return nil, err
+		xForwardedFor, err := resolver.handleXForwardedFor(ctx, req, srcIP)
+		if err == nil || !isInvalidHeaderError(err) {
+			return xForwardedFor, err
 		}
 
-		return xForwardedFor, nil
+		ip, done, err := resolver.settleHeader(ctx, XForwardedFor, netip.Addr{}, err, srcIP)
+		if done {
+			return ip, err
+		}
 	}
 
 	resolver.logger.DebugContext(ctx, "No trusted headers found, returning source IP")
@@ -119,14 +112,15 @@ func (resolver *IPResolver) getRealIP(
 	return srcIP, nil
 }
 
+// handleXForwardedFor returns the first entry the private client policy
+// settles on. When every entry is skipped, the source IP is used if a policy
+// is configured, and ErrNoValidIPInXForwardedFor is returned otherwise.
 func (resolver *IPResolver) handleXForwardedFor(
 	ctx context.Context,
 	req *http.Request,
-) (net.IP, error) {
+	srcIP netip.Addr,
+) (netip.Addr, error) {
 	xForwardedForList := req.Header.Values(XForwardedFor)
-	if len(xForwardedForList) != 1 {
-		return nil, ErrXForwardedForInvalid
-	}
 
 	resolver.logger.DebugContext(
 		ctx,
@@ -134,54 +128,82 @@ func (resolver *IPResolver) handleXForwardedFor(
 		slog.Any("value", xForwardedForList),
 	)
 
-	xForwardedForValuesStr := strings.Split(xForwardedForList[0], ",")
-	xForwardedForValues := make([]net.IP, 0)
-
-	for _, xForwardedForValue := range xForwardedForValuesStr {
-		tempIP := net.ParseIP(strings.TrimSpace(xForwardedForValue))
-		if tempIP != nil {
-			xForwardedForValues = append(xForwardedForValues, tempIP)
-		} else {
-			resolver.logger.DebugContext(
-				ctx,
-				"Invalid IP format in X-Forwarded-For",
-				slog.String("value", xForwardedForValue),
-			)
-		}
-	}
+	tokens := tokenizeXForwardedFor(xForwardedForList)
+	xForwardedForValues := make([]netip.Addr, 0, len(tokens))
+	invalid := make([]string, 0)
 
-	for _, xForwardedForValue := range xForwardedForValues {
-		if !resolver.isPrivateIP(xForwardedForValue) {
-			resolver.logger.DebugContext(
-				ctx,
-				"Found valid X-Forwarded-For IP",
-				slog.String("ip", xForwardedForValue.String()),
-			)
-
-			return xForwardedForValue, nil
+	for _, token := range tokens {
+		if token.err == nil {
+			xForwardedForValues = append(xForwardedForValues, token.addr)
+
+			continue
 		}
 
+		invalid = append(invalid, fmt.Sprintf("entry %d: %v", token.index, token.err))
+
 		resolver.logger.DebugContext(
 			ctx,
-			"X-Forwarded-For IP is a private IP, skipping",
-			slog.String("ip", xForwardedForValue.String()),
+			"Invalid entry in X-Forwarded-For",
+			slog.Int("index", token.index),
+			slog.String("value", token.value),
+			slog.Any("error", token.err),
+		)
+	}
+
+	if len(xForwardedForValues) == 0 {
+		if len(invalid) == 0 {
+			return netip.Addr{}, fmt.Errorf("%w: no entries", ErrXForwardedForInvalid)
+		}
+
+		return netip.Addr{}, fmt.Errorf(
+			"%w: %s", ErrXForwardedForInvalid, strings.Join(invalid, "; "),
+		)
+	}
+
+	for _, xForwardedForValue := range xForwardedForValues {
+		ip, done, err := resolver.applyPrivateClientPolicy(
+			ctx, XForwardedFor, xForwardedForValue, srcIP,
 		)
+		if done {
+			if ip == xForwardedForValue {
+				resolver.logger.DebugContext(
+					ctx,
+					"Found valid X-Forwarded-For IP",
+					slog.String("ip", ip.String()),
+				)
+			}
+
+			return ip, err
+		}
+	}
+
+	if resolver.privateClient == "" {
+		return netip.Addr{}, ErrNoValidIPInXForwardedFor
 	}
 
-	return nil, ErrNoValidIPInXForwardedFor
+	resolver.logger.DebugContext(
+		ctx,
+		"Every X-Forwarded-For IP was skipped, returning source IP",
+		slog.String("ip", srcIP.String()),
+	)
+
+	return srcIP, nil
 }
 
-func (resolver *IPResolver) handleXRealIP(ctx context.Context, req *http.Request) (net.IP, error) {
+func (resolver *IPResolver) handleXRealIP(
+	ctx context.Context,
+	req *http.Request,
+) (netip.Addr, error) {
 	realIPs := req.Header.Values(XRealIP)
 	if len(realIPs) != 1 {
-		return nil, ErrXRealIPInvalid
+		return netip.Addr{}, ErrXRealIPInvalid
 	}
 
 	resolver.logger.DebugContext(ctx, "Parsing X-Real-IP", slog.Any("value", realIPs))
 
-	tempIP := net.ParseIP(realIPs[0])
-	if tempIP == nil {
-		return nil, fmt.Errorf("%w in X-Real-IP: %s", ErrInvalidIPFormat, realIPs[0])
+	tempIP, err := parseIP(realIPs[0])
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%w in X-Real-IP: %s", ErrInvalidIPFormat, realIPs[0])
 	}
 
 	resolver.logger.DebugContext(ctx, "Found valid X-Real-IP", slog.String("ip", tempIP.String()))
@@ -189,17 +211,17 @@ func (resolver *IPResolver) handleXRealIP(ctx context.Context, req *http.Request
 	return tempIP, nil
 }
 
-func (resolver *IPResolver) handleCFIP(ctx context.Context, req *http.Request) (net.IP, error) {
+func (resolver *IPResolver) handleCFIP(ctx context.Context, req *http.Request) (netip.Addr, error) {
 	cfIPs := req.Header.Values(CfConnectingIP)
 	if len(cfIPs) != 1 {
-		return nil, ErrCfConnectingIPInvalid
+		return netip.Addr{}, ErrCfConnectingIPInvalid
 	}
 
 	resolver.logger.DebugContext(ctx, "Parsing Cf-Connecting-Ip", slog.Any("value", cfIPs))
 
-	tempIP := net.ParseIP(cfIPs[0])
-	if tempIP == nil {
-		return nil, fmt.Errorf("%w in Cf-Connecting-Ip: %s", ErrInvalidIPFormat, cfIPs[0])
+	tempIP, err := parseIP(cfIPs[0])
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%w in Cf-Connecting-Ip: %s", ErrInvalidIPFormat, cfIPs[0])
 	}
 
 	resolver.logger.DebugContext(
@@ -211,17 +233,17 @@ func (resolver *IPResolver) handleCFIP(ctx context.Context, req *http.Request) (
 	return tempIP, nil
 }
 
-func (resolver *IPResolver) handleEOIP(ctx context.Context, req *http.Request) (net.IP, error) {
+func (resolver *IPResolver) handleEOIP(ctx context.Context, req *http.Request) (netip.Addr, error) {
 	eoIPs := req.Header.Values(EoConnectingIP)
 	if len(eoIPs) != 1 {
-		return nil, ErrEoConnectingIPInvalid
+		return netip.Addr{}, ErrEoConnectingIPInvalid
 	}
 
 	resolver.logger.DebugContext(ctx, "Parsing Eo-Connecting-Ip", slog.Any("value", eoIPs))
 
-	tempIP := net.ParseIP(eoIPs[0])
-	if tempIP == nil {
-		return nil, fmt.Errorf("%w in Eo-Connecting-Ip: %s", ErrInvalidIPFormat, eoIPs[0])
+	tempIP, err := parseIP(eoIPs[0])
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%w in Eo-Connecting-Ip: %s", ErrInvalidIPFormat, eoIPs[0])
 	}
 
 	resolver.logger.DebugContext(
@@ -233,15 +255,15 @@ func (resolver *IPResolver) handleEOIP(ctx context.Context, req *http.Request) (
 	return tempIP, nil
 }
 
-func (resolver *IPResolver) getSrcIP(ctx context.Context, req *http.Request) (net.IP, error) {
+func (resolver *IPResolver) getSrcIP(ctx context.Context, req *http.Request) (netip.Addr, error) {
 	temp, _, err := net.SplitHostPort(req.RemoteAddr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to split host and port from RemoteAddr: %w", err)
+		return netip.Addr{}, fmt.Errorf("failed to split host and port from RemoteAddr: %w", err)
 	}
 
-	ip := net.ParseIP(temp)
-	if ip == nil {
-		return nil, fmt.Errorf("%w: %s", ErrInvalidIPFormat, temp)
+	ip, err := parseIP(temp)
+	if err != nil {
+		return netip.Addr{}, err
 	}
 
 	resolver.logger.DebugContext(ctx, "Parsed source IP", slog.String("ip", ip.String()))
@@ -1,8 +1,8 @@
 package traefik_real_ip
 
 import (
-	"net"
 	"net/http"
+	"net/netip"
 	"testing"
 )
 
@@ -11,7 +11,7 @@ func TestIPResolver_getRealIP(t *testing.T) {
 		logger: NewPluginLogger(t.Context(), "test", LogLevelDebug),
 	}
 
-	trustedIPNets := make([]*net.IPNet, 0, len(ipResolver.trustedIPNets))
+	trustedIPNets := make([]netip.Prefix, 0, len(ipResolver.trustedIPNets))
 	trustedIPNets = append(trustedIPNets, ipResolver.getLocalIPs(t.Context())...)
 	trustedIPNets = append(trustedIPNets, ipResolver.getCloudFlareIPs(t.Context())...)
 
@@ -40,16 +40,23 @@ func TestIPResolver_getRealIP(t *testing.T) {
 		{
 			name:         "X-Real-IP from trusted source",
 			srcIP:        "103.21.244.23",
+			headers:      map[string]string{XRealIP: "9.9.9.9"},
+			trustedCIDRs: []string{"1.1.1.0/24"},
+			expectedIP:   "9.9.9.9",
+		},
+		{
+			name:         "X-Real-IP with a documentation address falls back to the source",
+			srcIP:        "103.21.244.23",
 			headers:      map[string]string{XRealIP: "203.0.113.10"},
 			trustedCIDRs: []string{"1.1.1.0/24"},
-			expectedIP:   "203.0.113.10",
+			expectedIP:   "103.21.244.23",
 		},
 		{
 			name:         "X-Forwarded-For from trusted source",
 			srcIP:        "192.168.1.1",
-			headers:      map[string]string{XForwardedFor: "203.0.113.10, 192.168.1.1"},
+			headers:      map[string]string{XForwardedFor: "9.9.9.9, 192.168.1.1"},
 			trustedCIDRs: []string{"1.1.1.0/24"},
-			expectedIP:   "203.0.113.10",
+			expectedIP:   "9.9.9.9",
 		},
 		{
 			name:         "No headers, return source IP",
@@ -98,7 +105,7 @@ func TestIPResolver_getRealIP(t *testing.T) {
 				req.Header.Set(key, value)
 			}
 
-			srcIP := net.ParseIP(tt.srcIP)
+			srcIP := netip.MustParseAddr(tt.srcIP)
 			result, err := resolver.getRealIP(t.Context(), srcIP, req)
 
 			if tt.expectedError {
@@ -135,24 +142,29 @@ func TestIPResolver_handleXForwardedFor(t *testing.T) {
 	}{
 		{
 			name:        "Single public IP",
-			headerValue: "203.0.113.10",
-			expectedIP:  "203.0.113.10",
+			headerValue: "9.9.9.9",
+			expectedIP:  "9.9.9.9",
 		},
 		{
 			name:        "Multiple IPs, first public",
-			headerValue: "203.0.113.10, 192.168.1.1",
-			expectedIP:  "203.0.113.10",
+			headerValue: "9.9.9.9, 192.168.1.1",
+			expectedIP:  "9.9.9.9",
 		},
 		{
 			name:        "Multiple IPs, second public",
-			headerValue: "192.168.1.1, 203.0.113.10",
-			expectedIP:  "203.0.113.10",
+			headerValue: "192.168.1.1, 9.9.9.9",
+			expectedIP:  "9.9.9.9",
 		},
 		{
 			name:          "Only private IPs",
 			headerValue:   "192.168.1.1, 10.0.0.1",
 			expectedError: true,
 		},
+		{
+			name:        "Special-purpose IPs skipped",
+			headerValue: "100.64.0.1, 198.18.0.1, 192.0.2.1, 0.0.0.0, 2001:db8::1, 9.9.9.9",
+			expectedIP:  "9.9.9.9",
+		},
 		{
 			name:          "Invalid IP format",
 			headerValue:   "invalid-ip",
@@ -160,8 +172,8 @@ func TestIPResolver_handleXForwardedFor(t *testing.T) {
 		},
 		{
 			name:        "IPs with spaces",
-			headerValue: " 203.0.113.10 , 192.168.1.1 ",
-			expectedIP:  "203.0.113.10",
+			headerValue: " 9.9.9.9 , 192.168.1.1 ",
+			expectedIP:  "9.9.9.9",
 		},
 	}
 
@@ -170,7 +182,7 @@ func TestIPResolver_handleXForwardedFor(t *testing.T) {
 			req, _ := http.NewRequestWithContext(t.Context(), http.MethodGet, "/", http.NoBody)
 			req.Header.Set(XForwardedFor, tt.headerValue)
 
-			result, err := resolver.handleXForwardedFor(t.Context(), req)
+			result, err := resolver.handleXForwardedFor(t.Context(), req, netip.Addr{})
 
 			if tt.expectedError {
 				if err == nil {
@@ -418,6 +430,11 @@ func TestIPResolver_getSrcIP(t *testing.T) {
 			remoteAddr: "[2001:db8::1]:12345",
 			expectedIP: "2001:db8::1",
 		},
+		{
+			name:       "IPv4-mapped IPv6 with port",
+			remoteAddr: "[::ffff:203.0.113.10]:12345",
+			expectedIP: "203.0.113.10",
+		},
 		{
 			name:          "Invalid format - no port",
 			remoteAddr:    "203.0.113.10",
@@ -1,20 +1,20 @@
 package traefik_real_ip
 
 import (
-	"net"
+	"net/netip"
 	"testing"
 )
 
 func TestIPResolver_isTrustedIP(t *testing.T) {
 	logger := NewPluginLogger(t.Context(), "test", LogLevelDebug)
 
-	_, trustedNet1, _ := net.ParseCIDR("192.168.1.0/24")
-	_, trustedNet2, _ := net.ParseCIDR("10.0.0.0/8")
-	_, trustedNet3, _ := net.ParseCIDR("172.16.0.0/12")
-
 	resolver := &IPResolver{
-		trustedIPNets: []*net.IPNet{trustedNet1, trustedNet2, trustedNet3},
-		logger:        logger,
+		trustedIPNets: []netip.Prefix{
+			netip.MustParsePrefix("192.168.1.0/24"),
+			netip.MustParsePrefix("10.0.0.0/8"),
+			netip.MustParsePrefix("172.16.0.0/12"),
+		},
+		logger: logger,
 	}
 
 	tests := []struct {
@@ -56,8 +56,8 @@ func TestIPResolver_isTrustedIP(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ip := net.ParseIP(tt.ip)
-			if ip == nil {
+			ip, err := netip.ParseAddr(tt.ip)
+			if err != nil {
 				t.Fatalf("failed to parse IP: %s", tt.ip)
 			}
 
@@ -131,8 +131,8 @@ func TestIPResolver_isPrivateIP(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ip := net.ParseIP(tt.ip)
-			if ip == nil {
+			ip, err := netip.ParseAddr(tt.ip)
+			if err != nil {
 				t.Fatalf("failed to parse IP: %s", tt.ip)
 			}
 
@@ -147,14 +147,65 @@ func TestIPResolver_isPrivateIP(t *testing.T) {
 func TestIPResolver_isTrustedIP_EmptyTrustedNets(t *testing.T) {
 	logger := NewPluginLogger(t.Context(), "test", LogLevelDebug)
 	resolver := &IPResolver{
-		trustedIPNets: []*net.IPNet{},
+		trustedIPNets: []netip.Prefix{},
 		logger:        logger,
 	}
 
-	ip := net.ParseIP("192.168.1.1")
+	ip := netip.MustParseAddr("192.168.1.1")
 	result := resolver.isTrustedIP(t.Context(), ip)
 
 	if result != false {
 		t.Errorf("isTrustedIP with empty trusted nets should return false, got %v", result)
 	}
 }
+
+func TestIPResolver_isTrustedIP_IPv4Mapped(t *testing.T) {
+	resolver := &IPResolver{
+		trustedIPNets: []netip.Prefix{netip.MustParsePrefix("1.2.3.0/24")},
+		logger:        NewPluginLogger(t.Context(), "test", LogLevelDebug),
+	}
+
+	ip := netip.MustParseAddr("::ffff:1.2.3.4")
+	if !resolver.isTrustedIP(t.Context(), ip) {
+		t.Errorf("expected IPv4-mapped %s to match 1.2.3.0/24", ip)
+	}
+
+	if !resolver.isPrivateIP(netip.MustParseAddr("::ffff:10.0.0.1")) {
+		t.Error("expected IPv4-mapped 10.0.0.1 to be private")
+	}
+}
+
+func TestParsePrefix(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{input: "10.1.2.3/8", expected: "10.0.0.0/8"},
+		{input: " 2001:db8::1/32 ", expected: "2001:db8::/32"},
+		{input: "::ffff:1.2.3.0/120", expected: "1.2.3.0/24"},
+		{input: "not-a-cidr", wantErr: true},
+		{input: "1.2.3.4", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			prefix, err := parsePrefix(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error for %q, got %s", tt.input, prefix)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if prefix.String() != tt.expected {
+				t.Errorf("parsePrefix(%q) = %s, want %s", tt.input, prefix, tt.expected)
+			}
+		})
+	}
+}
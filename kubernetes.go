@@ -0,0 +1,472 @@
+package traefik_real_ip
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidKubernetesConfig = errors.New("invalid Kubernetes configuration")
+	ErrKubernetesAPI           = errors.New("kubernetes API request failed")
+)
+
+const (
+	kubernetesLabelPrefix            = "kubernetes:"
+	kubernetesNodesLabel             = kubernetesLabelPrefix + "nodes"
+	defaultKubernetesTokenFile       = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	defaultKubernetesCAFile          = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	defaultKubernetesRefreshInterval = 30 * time.Second
+	defaultKubernetesTimeout         = 5 * time.Second
+	kubernetesPageSize               = "500"
+	maxKubernetesResponseSize        = 16 << 20
+)
+
+// KubernetesConfig selects the Kubernetes ranges that are trusted: the pod
+// CIDRs of every node and the endpoints of the given services, written as
+// namespace/name.
+type KubernetesConfig struct {
+	APIURL          string   `json:"apiURL,omitempty"`
+	TokenFile       string   `json:"tokenFile,omitempty"`
+	CAFile          string   `json:"caFile,omitempty"`
+	Timeout         string   `json:"timeout,omitempty"`
+	RefreshInterval string   `json:"refreshInterval,omitempty"`
+	EndpointSlices  []string `json:"endpointSlices,omitempty"`
+	NodePodCIDRs    bool     `json:"nodePodCIDRs,omitempty"`
+}
+
+// kubernetesSettings is the validated form of a KubernetesConfig.
+type kubernetesSettings struct {
+	client    *http.Client
+	apiURL    string
+	tokenFile string
+	services  []kubernetesService
+	interval  time.Duration
+	nodes     bool
+}
+
+type kubernetesService struct {
+	namespace string
+	name      string
+}
+
+type kubernetesListMeta struct {
+	Continue string `json:"continue"`
+}
+
+type kubernetesNodeList struct {
+	Metadata kubernetesListMeta `json:"metadata"`
+	Items    []kubernetesNode   `json:"items"`
+}
+
+type kubernetesNode struct {
+	Spec kubernetesNodeSpec `json:"spec"`
+}
+
+type kubernetesNodeSpec struct {
+	PodCIDR  string   `json:"podCIDR"`
+	PodCIDRs []string `json:"podCIDRs"`
+}
+
+type kubernetesEndpointSliceList struct {
+	Metadata kubernetesListMeta        `json:"metadata"`
+	Items    []kubernetesEndpointSlice `json:"items"`
+}
+
+type kubernetesEndpointSlice struct {
+	AddressType string               `json:"addressType"`
+	Endpoints   []kubernetesEndpoint `json:"endpoints"`
+}
+
+type kubernetesEndpoint struct {
+	Conditions kubernetesEndpointConditions `json:"conditions"`
+	Addresses  []string                     `json:"addresses"`
+}
+
+type kubernetesEndpointConditions struct {
+	Ready *bool `json:"ready"`
+}
+
+// label names the part of the trust table filled with the service endpoints.
+func (service kubernetesService) label() string {
+	return kubernetesLabelPrefix + service.namespace + "/" + service.name
+}
+
+// parseKubernetesConfig validates a KubernetesConfig. Without an apiURL the
+// in-cluster service address is used.
+func parseKubernetesConfig(config KubernetesConfig) (kubernetesSettings, error) {
+	if !config.NodePodCIDRs && len(config.EndpointSlices) == 0 {
+		return kubernetesSettings{}, nil
+	}
+
+	settings := kubernetesSettings{
+		nodes:     config.NodePodCIDRs,
+		tokenFile: config.TokenFile,
+		interval:  defaultKubernetesRefreshInterval,
+	}
+
+	if settings.tokenFile == "" {
+		settings.tokenFile = defaultKubernetesTokenFile
+	}
+
+	timeout := defaultKubernetesTimeout
+
+	durations := []struct {
+		target *time.Duration
+		field  string
+		value  string
+	}{
+		{target: &timeout, field: "timeout", value: config.Timeout},
+		{target: &settings.interval, field: "refreshInterval", value: config.RefreshInterval},
+	}
+
+	for _, duration := range durations {
+		if duration.value == "" {
+			continue
+		}
+
+		parsed, err := time.ParseDuration(duration.value)
+		if err != nil || parsed < 0 {
+			return kubernetesSettings{}, fmt.Errorf(
+				"%w: %s: %q", ErrInvalidKubernetesConfig, duration.field, duration.value,
+			)
+		}
+
+		*duration.target = parsed
+	}
+
+	if timeout == 0 {
+		return kubernetesSettings{}, fmt.Errorf(
+			"%w: timeout must be positive", ErrInvalidKubernetesConfig,
+		)
+	}
+
+	for _, entry := range config.EndpointSlices {
+		namespace, name, ok := strings.Cut(strings.TrimSpace(entry), "/")
+		if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+			return kubernetesSettings{}, fmt.Errorf(
+				"%w: endpointSlices: expected namespace/name: %q", ErrInvalidKubernetesConfig, entry,
+			)
+		}
+
+		settings.services = append(settings.services, kubernetesService{
+			namespace: namespace,
+			name:      name,
+		})
+	}
+
+	apiURL, err := kubernetesAPIURL(config.APIURL)
+	if err != nil {
+		return kubernetesSettings{}, err
+	}
+
+	settings.apiURL = apiURL
+
+	tlsConfig, err := kubernetesTLSConfig(config.CAFile)
+	if err != nil {
+		return kubernetesSettings{}, err
+	}
+
+	transport := &http.Transport{TLSClientConfig: tlsConfig}
+	settings.client = &http.Client{Timeout: timeout, Transport: transport}
+
+	return settings, nil
+}
+
+func kubernetesAPIURL(value string) (string, error) {
+	if value == "" {
+		host := os.Getenv("KUBERNETES_SERVICE_HOST")
+		port := os.Getenv("KUBERNETES_SERVICE_PORT")
+
+		if host == "" || port == "" {
+			return "", fmt.Errorf(
+				"%w: not running in a cluster, apiURL is required", ErrInvalidKubernetesConfig,
+			)
+		}
+
+		return "https://" + net.JoinHostPort(host, port), nil
+	}
+
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("%w: apiURL: %q", ErrInvalidKubernetesConfig, redactURL(value))
+	}
+
+	return strings.TrimSuffix(value, "/"), nil
+}
+
+// kubernetesTLSConfig trusts the cluster CA. The default service account CA
+// may be missing, e.g. for a local stand-in, in which case the system pool
+// is used.
+func kubernetesTLSConfig(caFile string) (*tls.Config, error) {
+	path := caFile
+	if path == "" {
+		path = defaultKubernetesCAFile
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if caFile == "" && errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("%w: caFile: %w", ErrInvalidKubernetesConfig, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf(
+			"%w: caFile: no certificate found in %q", ErrInvalidKubernetesConfig, path,
+		)
+	}
+
+	return &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}, nil
+}
+
+// watchKubernetes trusts the configured Kubernetes ranges and refreshes them
+// every interval until ctx is done.
+func (resolver *IPResolver) watchKubernetes(ctx context.Context) {
+	if !resolver.kubernetes.nodes && len(resolver.kubernetes.services) == 0 {
+		return
+	}
+
+	ranges := make(map[string][]netip.Prefix)
+	resolver.refreshKubernetes(ctx, ranges)
+
+	if resolver.kubernetes.interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(resolver.kubernetes.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				resolver.refreshKubernetes(ctx, ranges)
+			}
+		}
+	}()
+}
+
+// refreshKubernetes installs the ranges that differ from the last good ones
+// in ranges. A source that cannot be listed keeps its ranges.
+func (resolver *IPResolver) refreshKubernetes(
+	ctx context.Context,
+	ranges map[string][]netip.Prefix,
+) {
+	updates := make(map[string][]netip.Prefix)
+
+	if resolver.kubernetes.nodes {
+		prefixes, err := resolver.listNodePodCIDRs(ctx)
+		resolver.updateKubernetesRanges(ctx, kubernetesNodesLabel, prefixes, err, ranges, updates)
+	}
+
+	for _, service := range resolver.kubernetes.services {
+		prefixes, err := resolver.listServiceEndpoints(ctx, service)
+		resolver.updateKubernetesRanges(ctx, service.label(), prefixes, err, ranges, updates)
+	}
+
+	if len(updates) > 0 {
+		resolver.setTrustedSources(updates)
+	}
+}
+
+func (resolver *IPResolver) updateKubernetesRanges(
+	ctx context.Context,
+	label string,
+	prefixes []netip.Prefix,
+	err error,
+	ranges, updates map[string][]netip.Prefix,
+) {
+	if err != nil {
+		resolver.logger.ErrorContext(
+			ctx,
+			"Error listing Kubernetes ranges, keeping the last good ranges",
+			slog.String("source", label),
+			slog.Int("count", len(ranges[label])),
+			slog.Any("error", err),
+		)
+
+		return
+	}
+
+	previous, ok := ranges[label]
+	if ok && equalPrefixes(prefixes, previous) {
+		return
+	}
+
+	ranges[label] = prefixes
+	updates[label] = prefixes
+
+	resolver.logger.InfoContext(
+		ctx,
+		"Synchronised Kubernetes ranges",
+		slog.String("source", label),
+		slog.Int("count", len(prefixes)),
+	)
+}
+
+// listNodePodCIDRs returns the pod CIDRs of every node. An empty result is
+// an error, since clusters that assign pod CIDRs always have some.
+func (resolver *IPResolver) listNodePodCIDRs(ctx context.Context) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0)
+	params := url.Values{}
+
+	for {
+		var nodes kubernetesNodeList
+
+		err := resolver.getKubernetesList(ctx, "/api/v1/nodes", params, &nodes)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, node := range nodes.Items {
+			cidrs := node.Spec.PodCIDRs
+			if len(cidrs) == 0 && node.Spec.PodCIDR != "" {
+				cidrs = []string{node.Spec.PodCIDR}
+			}
+
+			for _, cidr := range cidrs {
+				prefix, err := parsePrefix(cidr)
+				if err != nil {
+					return nil, fmt.Errorf("%w: pod CIDR %q: %w", ErrKubernetesAPI, cidr, err)
+				}
+
+				prefixes = append(prefixes, prefix)
+			}
+		}
+
+		if nodes.Metadata.Continue == "" {
+			break
+		}
+
+		params.Set("continue", nodes.Metadata.Continue)
+	}
+
+	if len(prefixes) == 0 {
+		return nil, fmt.Errorf("%w: no node has a pod CIDR", ErrKubernetesAPI)
+	}
+
+	return prefixes, nil
+}
+
+// listServiceEndpoints returns the addresses of the endpoints of service
+// that are not known to be unready.
+func (resolver *IPResolver) listServiceEndpoints(
+	ctx context.Context,
+	service kubernetesService,
+) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0)
+	path := "/apis/discovery.k8s.io/v1/namespaces/" + url.PathEscape(service.namespace) +
+		"/endpointslices"
+
+	params := url.Values{}
+	params.Set("labelSelector", "kubernetes.io/service-name="+service.name)
+
+	for {
+		var slices kubernetesEndpointSliceList
+
+		err := resolver.getKubernetesList(ctx, path, params, &slices)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, slice := range slices.Items {
+			if slice.AddressType == "FQDN" {
+				continue
+			}
+
+			for _, endpoint := range slice.Endpoints {
+				if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+					continue
+				}
+
+				for _, address := range endpoint.Addresses {
+					ip, err := parseIP(address)
+					if err != nil {
+						return nil, fmt.Errorf("%w: endpoint: %w", ErrKubernetesAPI, err)
+					}
+
+					prefixes = append(prefixes, netip.PrefixFrom(ip, ip.BitLen()))
+				}
+			}
+		}
+
+		if slices.Metadata.Continue == "" {
+			break
+		}
+
+		params.Set("continue", slices.Metadata.Continue)
+	}
+
+	return prefixes, nil
+}
+
+// getKubernetesList fetches one page of a list request into list.
+func (resolver *IPResolver) getKubernetesList(
+	ctx context.Context,
+	path string,
+	params url.Values,
+	list any,
+) error {
+	params.Set("limit", kubernetesPageSize)
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		resolver.kubernetes.apiURL+path+"?"+params.Encode(),
+		http.NoBody,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating HTTP request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	// Service account tokens are rotated, so the file is read every time. A
+	// missing token, e.g. for a local stand-in, sends the request anonymously,
+	// and so does a plain HTTP API URL, which would leak the token.
+	if strings.HasPrefix(resolver.kubernetes.apiURL, "https://") {
+		token, err := os.ReadFile(resolver.kubernetes.tokenFile)
+		switch {
+		case err == nil:
+			req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+		case !errors.Is(err, fs.ErrNotExist):
+			return fmt.Errorf("%w: error reading token: %w", ErrKubernetesAPI, err)
+		}
+	}
+
+	resp, err := resolver.kubernetes.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrKubernetesAPI, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s: %s", ErrKubernetesAPI, path, resp.Status)
+	}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxKubernetesResponseSize)).Decode(list)
+	if err != nil {
+		return fmt.Errorf("%w: error decoding response: %w", ErrKubernetesAPI, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,254 @@
+package traefik_real_ip
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// fakeKubernetesAPI serves node and EndpointSlice lists, paging the nodes
+// one per response.
+type fakeKubernetesAPI struct {
+	nodes     [][]string
+	endpoints map[string][]kubernetesEndpoint
+	tokens    []string
+	mu        sync.Mutex
+}
+
+func newFakeKubernetesAPI(t *testing.T) (*fakeKubernetesAPI, *httptest.Server) {
+	t.Helper()
+
+	api := &fakeKubernetesAPI{endpoints: make(map[string][]kubernetesEndpoint)}
+	server := httptest.NewServer(http.HandlerFunc(api.serveHTTP))
+	t.Cleanup(server.Close)
+
+	return api, server
+}
+
+func (api *fakeKubernetesAPI) serveHTTP(rw http.ResponseWriter, req *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	api.tokens = append(api.tokens, req.Header.Get("Authorization"))
+
+	switch req.URL.Path {
+	case "/api/v1/nodes":
+		index := 0
+		if req.URL.Query().Get("continue") != "" {
+			index = 1
+		}
+
+		list := kubernetesNodeList{}
+		if index < len(api.nodes) {
+			list.Items = []kubernetesNode{{Spec: kubernetesNodeSpec{PodCIDRs: api.nodes[index]}}}
+		}
+
+		if index+1 < len(api.nodes) {
+			list.Metadata.Continue = "next"
+		}
+
+		_ = json.NewEncoder(rw).Encode(list)
+	case "/apis/discovery.k8s.io/v1/namespaces/ingress/endpointslices":
+		service := req.URL.Query().Get("labelSelector")
+
+		endpoints, ok := api.endpoints[service]
+		if !ok {
+			http.Error(rw, "forbidden", http.StatusForbidden)
+
+			return
+		}
+
+		_ = json.NewEncoder(rw).Encode(kubernetesEndpointSliceList{
+			Items: []kubernetesEndpointSlice{
+				{AddressType: "IPv4", Endpoints: endpoints},
+				{AddressType: "FQDN", Endpoints: []kubernetesEndpoint{{Addresses: []string{"lb"}}}},
+			},
+		})
+	default:
+		http.NotFound(rw, req)
+	}
+}
+
+func (api *fakeKubernetesAPI) setEndpoints(service string, endpoints ...kubernetesEndpoint) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	api.endpoints["kubernetes.io/service-name="+service] = endpoints
+}
+
+func TestParseKubernetesConfig(t *testing.T) {
+	settings, err := parseKubernetesConfig(KubernetesConfig{})
+	if err != nil || settings.client != nil {
+		t.Fatalf("expected a disabled provider, got %+v, %v", settings, err)
+	}
+
+	t.Setenv("KUBERNETES_SERVICE_HOST", "fd00::1")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "443")
+
+	settings, err = parseKubernetesConfig(KubernetesConfig{
+		NodePodCIDRs:   true,
+		EndpointSlices: []string{"ingress/traefik"},
+	})
+	if err != nil {
+		t.Fatalf("parseKubernetesConfig: %v", err)
+	}
+
+	if settings.apiURL != "https://[fd00::1]:443" ||
+		settings.tokenFile != defaultKubernetesTokenFile ||
+		len(settings.services) != 1 || settings.services[0].label() != "kubernetes:ingress/traefik" {
+		t.Errorf("unexpected settings %+v", settings)
+	}
+
+	for _, config := range []KubernetesConfig{
+		{EndpointSlices: []string{"traefik"}},
+		{EndpointSlices: []string{"ingress/traefik/extra"}},
+		{NodePodCIDRs: true, APIURL: "ftp://cluster"},
+		{NodePodCIDRs: true, RefreshInterval: "often"},
+		{NodePodCIDRs: true, Timeout: "0s"},
+		{NodePodCIDRs: true, CAFile: filepath.Join(t.TempDir(), "missing.crt")},
+	} {
+		_, err := parseKubernetesConfig(config)
+		if !errors.Is(err, ErrInvalidKubernetesConfig) {
+			t.Errorf("%+v: expected ErrInvalidKubernetesConfig, got %v", config, err)
+		}
+	}
+
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	_, err = parseKubernetesConfig(KubernetesConfig{NodePodCIDRs: true})
+	if !errors.Is(err, ErrInvalidKubernetesConfig) {
+		t.Errorf("expected ErrInvalidKubernetesConfig outside a cluster, got %v", err)
+	}
+}
+
+func TestNew_Kubernetes(t *testing.T) {
+	api, server := newFakeKubernetesAPI(t)
+	api.nodes = [][]string{{"10.244.0.0/24", "fd00:244::/64"}, {"10.244.1.0/24"}}
+
+	unready := false
+	api.setEndpoints("traefik",
+		kubernetesEndpoint{Addresses: []string{"10.96.0.10"}},
+		kubernetesEndpoint{
+			Addresses:  []string{"10.96.0.11"},
+			Conditions: kubernetesEndpointConditions{Ready: &unready},
+		},
+	)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	writeSourceFile(t, tokenFile, "secret\n")
+
+	resolver := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.Kubernetes = KubernetesConfig{
+			APIURL:          server.URL,
+			TokenFile:       tokenFile,
+			NodePodCIDRs:    true,
+			EndpointSlices:  []string{"ingress/traefik", "ingress/other"},
+			RefreshInterval: "5ms",
+		}
+	})
+
+	ctx := t.Context()
+
+	for _, ip := range []string{"10.244.0.7", "fd00:244::7", "10.244.1.7"} {
+		trusted, provider := resolver.matchTrustedIP(ctx, netip.MustParseAddr(ip))
+		if !trusted || provider != kubernetesNodesLabel {
+			t.Errorf("%s: expected a trusted pod CIDR, got %v %q", ip, trusted, provider)
+		}
+	}
+
+	trusted, provider := resolver.matchTrustedIP(ctx, netip.MustParseAddr("10.96.0.10"))
+	if !trusted || provider != "kubernetes:ingress/traefik" {
+		t.Errorf("expected the ready endpoint to be trusted, got %v %q", trusted, provider)
+	}
+
+	if resolver.isTrustedIP(ctx, netip.MustParseAddr("10.96.0.11")) {
+		t.Error("expected the unready endpoint to be untrusted")
+	}
+
+	api.mu.Lock()
+	token := api.tokens[0]
+	api.mu.Unlock()
+
+	if token != "" {
+		t.Errorf("expected no token over plain HTTP, got %q", token)
+	}
+
+	// The service that could not be listed is picked up once readable.
+	api.setEndpoints("other", kubernetesEndpoint{Addresses: []string{"10.96.1.10"}})
+	api.setEndpoints("traefik")
+
+	waitFor(t, func() bool {
+		return resolver.isTrustedIP(ctx, netip.MustParseAddr("10.96.1.10")) &&
+			!resolver.isTrustedIP(ctx, netip.MustParseAddr("10.96.0.10"))
+	})
+}
+
+func TestListNodePodCIDRs_TokenOverHTTPS(t *testing.T) {
+	api := &fakeKubernetesAPI{endpoints: make(map[string][]kubernetesEndpoint)}
+	api.nodes = [][]string{{"10.244.0.0/24"}}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(api.serveHTTP))
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	tokenFile := filepath.Join(dir, "token")
+
+	writeSourceFile(t, caFile, string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	})))
+	writeSourceFile(t, tokenFile, "secret\n")
+
+	settings, err := parseKubernetesConfig(KubernetesConfig{
+		APIURL:       server.URL,
+		TokenFile:    tokenFile,
+		CAFile:       caFile,
+		NodePodCIDRs: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := newTestResolver(t)
+	resolver.kubernetes = settings
+
+	_, err = resolver.listNodePodCIDRs(t.Context())
+	if err != nil {
+		t.Fatalf("listNodePodCIDRs: %v", err)
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if len(api.tokens) == 0 || api.tokens[0] != "Bearer secret" {
+		t.Errorf("expected the service account token, got %q", api.tokens)
+	}
+}
+
+func TestListNodePodCIDRs_Empty(t *testing.T) {
+	_, server := newFakeKubernetesAPI(t)
+
+	settings, err := parseKubernetesConfig(KubernetesConfig{
+		APIURL:       server.URL,
+		TokenFile:    filepath.Join(t.TempDir(), "missing"),
+		NodePodCIDRs: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := newTestResolver(t)
+	resolver.kubernetes = settings
+
+	_, err = resolver.listNodePodCIDRs(t.Context())
+	if !errors.Is(err, ErrKubernetesAPI) {
+		t.Errorf("expected ErrKubernetesAPI, got %v", err)
+	}
+}
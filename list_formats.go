@@ -0,0 +1,346 @@
+package traefik_real_ip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/bits"
+	"net/netip"
+	"strings"
+)
+
+var (
+	ErrUnknownListFormat = errors.New("unknown range list format")
+	ErrInvalidListEntry  = errors.New("invalid range list entry")
+)
+
+// listEntry is an address, prefix or range found in a range list.
+type listEntry struct {
+	value string
+	line  int
+}
+
+// parseListFormat validates a range list format name. An empty name selects
+// the plain format.
+func parseListFormat(format string) (string, error) {
+	switch format = strings.ToLower(strings.TrimSpace(format)); format {
+	case "":
+		return ListFormatPlain, nil
+	case ListFormatPlain, ListFormatNginx, ListFormatApache, ListFormatHAProxy, ListFormatIPSet:
+		return format, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownListFormat, format)
+	}
+}
+
+// ParseRangeList extracts the trusted ranges of a list in the given format:
+//
+//   - plain: one CIDR per line, as published by Cloudflare
+//   - nginx: set_real_ip_from directives
+//   - apache: a mod_remoteip RemoteIPTrustedProxyList file, or
+//     RemoteIPTrustedProxy and RemoteIPInternalProxy directives
+//   - haproxy: an ACL or map file, using the first column
+//   - ipset: the output of ipset save
+//
+// Except in the plain format, entries may also be bare addresses, address
+// ranges or IPv4 netmasks.
+func ParseRangeList(format, body string) ([]netip.Prefix, error) {
+	format, err := parseListFormat(format)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []listEntry
+
+	switch format {
+	case ListFormatNginx:
+		entries = nginxEntries(body)
+	case ListFormatApache:
+		entries = apacheEntries(body)
+	case ListFormatHAProxy:
+		entries = haproxyEntries(body)
+	case ListFormatIPSet:
+		entries = ipsetEntries(body)
+	default:
+		return plainPrefixes(body)
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(entries))
+
+	for _, entry := range entries {
+		parsed, err := parseListEntry(entry.value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %q: %w", ErrInvalidListEntry, entry.line, entry.value, err)
+		}
+
+		prefixes = append(prefixes, parsed...)
+	}
+
+	return prefixes, nil
+}
+
+// parseRanges parses a provider or file body in the given format.
+func (resolver *IPResolver) parseRanges(
+	ctx context.Context,
+	body, source, format string,
+) ([]netip.Prefix, error) {
+	if format == "" || format == ListFormatPlain {
+		return resolver.parseCIDRs(ctx, body, source)
+	}
+
+	ips, err := ParseRangeList(format, body)
+	if err != nil {
+		resolver.logger.ErrorContext(
+			ctx,
+			"Error parsing range list",
+			slog.String("provider", source),
+			slog.String("format", format),
+			slog.Any("error", err),
+		)
+
+		return nil, err
+	}
+
+	return ips, nil
+}
+
+func plainPrefixes(body string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0)
+
+	for number, line := range lines(body) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		prefix, err := parsePrefix(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %q: %w", ErrInvalidListEntry, number+1, line, err)
+		}
+
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes, nil
+}
+
+// nginxEntries collects the set_real_ip_from values of an nginx
+// configuration. Other directives and unix: sockets are ignored.
+func nginxEntries(body string) []listEntry {
+	entries := make([]listEntry, 0)
+
+	for number, line := range lines(body) {
+		line, _, _ = strings.Cut(line, "#")
+
+		//nolint:modernize // yaegi does not support strings.SplitSeq
+		for _, statement := range strings.Split(line, ";") {
+			fields := strings.Fields(statement)
+			if len(fields) != 2 || fields[0] != "set_real_ip_from" {
+				continue
+			}
+
+			if strings.HasPrefix(fields[1], "unix:") {
+				continue
+			}
+
+			entries = append(entries, listEntry{value: fields[1], line: number + 1})
+		}
+	}
+
+	return entries
+}
+
+// apacheEntries reads a RemoteIPTrustedProxyList file, in which every
+// whitespace separated token is an entry, as well as the arguments of
+// RemoteIPTrustedProxy and RemoteIPInternalProxy directives.
+func apacheEntries(body string) []listEntry {
+	entries := make([]listEntry, 0)
+
+	for number, line := range lines(body) {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		directive := strings.ToLower(fields[0])
+
+		switch {
+		case directive == "remoteiptrustedproxy" || directive == "remoteipinternalproxy":
+			fields = fields[1:]
+		case strings.HasPrefix(directive, "remoteip"):
+			continue
+		}
+
+		for _, field := range fields {
+			if strings.HasPrefix(field, "#") {
+				break
+			}
+
+			entries = append(entries, listEntry{value: field, line: number + 1})
+		}
+	}
+
+	return entries
+}
+
+// haproxyEntries reads the patterns of an HAProxy ACL file, or the keys of a
+// map file.
+func haproxyEntries(body string) []listEntry {
+	entries := make([]listEntry, 0)
+
+	for number, line := range lines(body) {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		entries = append(entries, listEntry{value: fields[0], line: number + 1})
+	}
+
+	return entries
+}
+
+// ipsetEntries reads the add commands of ipset save output. Entries marked
+// nomatch are exceptions of the set and are skipped.
+func ipsetEntries(body string) []listEntry {
+	entries := make([]listEntry, 0)
+
+	for number, line := range lines(body) {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "add" {
+			continue
+		}
+
+		nomatch := false
+
+		for _, option := range fields[3:] {
+			if option == "nomatch" {
+				nomatch = true
+			}
+		}
+
+		if nomatch {
+			continue
+		}
+
+		// Entries of hash:net,port and similar types list the address first.
+		value, _, _ := strings.Cut(fields[2], ",")
+
+		entries = append(entries, listEntry{value: value, line: number + 1})
+	}
+
+	return entries
+}
+
+func lines(body string) []string {
+	//nolint:modernize // yaegi does not support strings.SplitSeq
+	return strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+}
+
+// parseListEntry parses a CIDR, a bare address, an IPv4 address with a
+// netmask such as 10.0.0.0/255.0.0.0, or an address range start-end.
+func parseListEntry(value string) ([]netip.Prefix, error) {
+	if start, end, ok := strings.Cut(value, "-"); ok {
+		first, err := parseIP(start)
+		if err != nil {
+			return nil, err
+		}
+
+		last, err := parseIP(end)
+		if err != nil {
+			return nil, err
+		}
+
+		return rangePrefixes(first, last)
+	}
+
+	address, mask, ok := strings.Cut(value, "/")
+	if !ok {
+		addr, err := parseIP(value)
+		if err != nil {
+			return nil, err
+		}
+
+		return []netip.Prefix{netip.PrefixFrom(addr, addr.BitLen())}, nil
+	}
+
+	if strings.Contains(mask, ".") {
+		length, err := netmaskLength(mask)
+		if err != nil {
+			return nil, err
+		}
+
+		value = fmt.Sprintf("%s/%d", address, length)
+	}
+
+	prefix, err := parsePrefix(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return []netip.Prefix{prefix}, nil
+}
+
+func netmaskLength(mask string) (int, error) {
+	addr, err := netip.ParseAddr(mask)
+	if err != nil || !addr.Is4() {
+		return 0, fmt.Errorf("invalid netmask %q", mask)
+	}
+
+	octets := addr.As4()
+	value := uint32(octets[0])<<24 | uint32(octets[1])<<16 | uint32(octets[2])<<8 | uint32(octets[3])
+	length := bits.LeadingZeros32(^value)
+
+	if value<<length != 0 {
+		return 0, fmt.Errorf("non-contiguous netmask %q", mask)
+	}
+
+	return length, nil
+}
+
+// rangePrefixes returns the smallest set of prefixes covering first to last.
+func rangePrefixes(first, last netip.Addr) ([]netip.Prefix, error) {
+	if first.BitLen() != last.BitLen() || last.Less(first) {
+		return nil, fmt.Errorf("invalid range %s-%s", first, last)
+	}
+
+	prefixes := make([]netip.Prefix, 0)
+
+	for {
+		prefix := largestPrefixFrom(first, last)
+		prefixes = append(prefixes, prefix)
+
+		end := lastAddr(prefix)
+		if end == last {
+			return prefixes, nil
+		}
+
+		first = end.Next()
+	}
+}
+
+// largestPrefixFrom returns the largest prefix starting at first that ends
+// no later than last.
+func largestPrefixFrom(first, last netip.Addr) netip.Prefix {
+	for length := 0; length < first.BitLen(); length++ {
+		prefix := netip.PrefixFrom(first, length).Masked()
+		if prefix.Addr() == first && !last.Less(lastAddr(prefix)) {
+			return prefix
+		}
+	}
+
+	return netip.PrefixFrom(first, first.BitLen())
+}
+
+// lastAddr returns the highest address of prefix.
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Masked().Addr().AsSlice()
+	for i := prefix.Bits(); i < len(bytes)*8; i++ {
+		bytes[i/8] |= 1 << (7 - i%8)
+	}
+
+	addr, _ := netip.AddrFromSlice(bytes)
+
+	return addr
+}
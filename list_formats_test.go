@@ -0,0 +1,196 @@
+package traefik_real_ip
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func prefixStrings(prefixes []netip.Prefix) []string {
+	result := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		result = append(result, prefix.String())
+	}
+
+	return result
+}
+
+func TestParseRangeList(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		body   string
+		want   []string
+	}{
+		{
+			name:   "plain",
+			format: "",
+			body:   "# Cloudflare\n173.245.48.0/20\r\n\n2400:cb00::/32\n",
+			want:   []string{"173.245.48.0/20", "2400:cb00::/32"},
+		},
+		{
+			name:   "nginx",
+			format: ListFormatNginx,
+			body: "# Cloudflare\n" +
+				"set_real_ip_from 173.245.48.0/20;\n" +
+				"set_real_ip_from  2400:cb00::/32; # IPv6\n" +
+				"set_real_ip_from 192.0.2.1; set_real_ip_from unix:;\n" +
+				"real_ip_header CF-Connecting-IP;\n",
+			want: []string{"173.245.48.0/20", "2400:cb00::/32", "192.0.2.1/32"},
+		},
+		{
+			name:   "apache list",
+			format: ListFormatApache,
+			body:   "# proxies\n10.0.0.0/8 192.168.0.0/255.255.0.0\n2001:db8::1\n",
+			want:   []string{"10.0.0.0/8", "192.168.0.0/16", "2001:db8::1/128"},
+		},
+		{
+			name:   "apache directives",
+			format: ListFormatApache,
+			body: "RemoteIPHeader X-Forwarded-For\n" +
+				"RemoteIPTrustedProxy 173.245.48.0/20 103.21.244.0/22\n" +
+				"RemoteIPInternalProxy 10.0.0.0/8\n" +
+				"RemoteIPTrustedProxyList conf/trusted-proxies.lst\n",
+			want: []string{"173.245.48.0/20", "103.21.244.0/22", "10.0.0.0/8"},
+		},
+		{
+			name:   "haproxy acl and map",
+			format: ListFormatHAProxy,
+			body:   "# trusted\n173.245.48.0/20\n192.0.2.1 cloudflare\n\n",
+			want:   []string{"173.245.48.0/20", "192.0.2.1/32"},
+		},
+		{
+			name:   "ipset save",
+			format: ListFormatIPSet,
+			body: "create trusted hash:net family inet hashsize 1024 maxelem 65536\n" +
+				"add trusted 173.245.48.0/20\n" +
+				"add trusted 192.0.2.0/24 nomatch\n" +
+				"add trusted 10.0.0.1-10.0.0.6 timeout 600\n" +
+				"add ports 198.51.100.0/24,tcp:443\n",
+			want: []string{
+				"173.245.48.0/20",
+				"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31", "10.0.0.6/32",
+				"198.51.100.0/24",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefixes, err := ParseRangeList(tt.format, tt.body)
+			if err != nil {
+				t.Fatalf("ParseRangeList: %v", err)
+			}
+
+			if got := prefixStrings(prefixes); !slices.Equal(got, tt.want) {
+				t.Errorf("ParseRangeList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRangeList_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		body    string
+		wantErr error
+	}{
+		{name: "unknown format", format: "caddy", wantErr: ErrUnknownListFormat},
+		{
+			name:    "plain bare address",
+			format:  ListFormatPlain,
+			body:    "192.0.2.1",
+			wantErr: ErrInvalidListEntry,
+		},
+		{
+			name:    "nginx hostname",
+			format:  ListFormatNginx,
+			body:    "set_real_ip_from proxy.local;",
+			wantErr: ErrInvalidListEntry,
+		},
+		{
+			name:    "apache netmask",
+			format:  ListFormatApache,
+			body:    "10.0.0.0/255.0.255.0",
+			wantErr: ErrInvalidListEntry,
+		},
+		{
+			name:    "reversed range",
+			format:  ListFormatIPSet,
+			body:    "add s 10.0.0.9-10.0.0.1",
+			wantErr: ErrInvalidListEntry,
+		},
+		{
+			name:    "mixed range",
+			format:  ListFormatIPSet,
+			body:    "add s 10.0.0.1-2001:db8::1",
+			wantErr: ErrInvalidListEntry,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRangeList(tt.format, tt.body)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRangePrefixes(t *testing.T) {
+	tests := []struct {
+		first, last string
+		want        []string
+	}{
+		{first: "0.0.0.0", last: "255.255.255.255", want: []string{"0.0.0.0/0"}},
+		{first: "10.0.0.0", last: "10.0.1.255", want: []string{"10.0.0.0/23"}},
+		{first: "192.0.2.255", last: "192.0.3.0", want: []string{"192.0.2.255/32", "192.0.3.0/32"}},
+		{
+			first: "2001:db8::",
+			last:  "2001:db8::ffff:ffff:ffff:ffff",
+			want:  []string{"2001:db8::/64"},
+		},
+		{first: "::", last: "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", want: []string{"::/0"}},
+	}
+
+	for _, tt := range tests {
+		prefixes, err := rangePrefixes(netip.MustParseAddr(tt.first), netip.MustParseAddr(tt.last))
+		if err != nil {
+			t.Fatalf("rangePrefixes(%s, %s): %v", tt.first, tt.last, err)
+		}
+
+		if got := prefixStrings(prefixes); !slices.Equal(got, tt.want) {
+			t.Errorf("rangePrefixes(%s, %s) = %v, want %v", tt.first, tt.last, got, tt.want)
+		}
+	}
+}
+
+func TestGetProviderIPsFromURL_Format(t *testing.T) {
+	path := t.TempDir() + "/nginx.conf"
+	writeSourceFile(t, path, "set_real_ip_from 173.245.48.0/20;\nset_real_ip_from 103.21.244.0/22;\n")
+
+	settings, err := parseProviderConfig("test", ProviderConfig{Format: "NGINX"})
+	if err != nil {
+		t.Fatalf("parseProviderConfig: %v", err)
+	}
+
+	resolver := newTestResolver(t)
+	resolver.providerSettings["test"] = settings
+
+	ips, err := resolver.getProviderIPsFromURL(t.Context(), "test", "file://"+path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(ips) != 2 {
+		t.Errorf("expected 2 ranges, got %v", ips)
+	}
+
+	_, err = parseProviderConfig("test", ProviderConfig{Format: "caddy"})
+	if !errors.Is(err, ErrUnknownListFormat) {
+		t.Errorf("expected ErrUnknownListFormat, got %v", err)
+	}
+}
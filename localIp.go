@@ -4,33 +4,30 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"net"
-	"sync"
+	"net/netip"
 )
 
-var (
-	localIPsInstance []*net.IPNet
-	localIPsOnce     sync.Once
-)
-
-func (resolver *IPResolver) getLocalIPs(ctx context.Context) []*net.IPNet {
-	localIPsOnce.Do(func() {
-		ips, err := resolver.getLocalIPsHardcoded(ctx)
-		if err != nil {
-			resolver.logger.ErrorContext(ctx, "Error fetching local IPs", slog.Any("error", err))
+const localProviderName = "local"
 
-			return
-		}
+func (resolver *IPResolver) getLocalIPs(ctx context.Context) []netip.Prefix {
+	return resolver.lookupProvider(
+		ctx,
+		providerKey(localProviderName, nil),
+		func(ctx context.Context) ([]netip.Prefix, bool) {
+			ips, err := resolver.getLocalIPsHardcoded(ctx)
+			if err != nil {
+				resolver.logger.ErrorContext(ctx, "Error fetching local IPs", slog.Any("error", err))
 
-		localIPsInstance = make([]*net.IPNet, 0, len(ips))
-		localIPsInstance = append(localIPsInstance, ips...)
-	})
+				return nil, false
+			}
 
-	return localIPsInstance
+			return ips, true
+		},
+	)
 }
 
-func (resolver *IPResolver) getLocalIPsHardcoded(ctx context.Context) ([]*net.IPNet, error) {
-	ips := make([]*net.IPNet, 0)
+func (resolver *IPResolver) getLocalIPsHardcoded(ctx context.Context) ([]netip.Prefix, error) {
+	ips := make([]netip.Prefix, 0)
 
 	localIPRanges := []string{
 		"127.0.0.0/8",    // IPv4 loopback
@@ -42,7 +39,7 @@ func (resolver *IPResolver) getLocalIPsHardcoded(ctx context.Context) ([]*net.IP
 		"fe80::/10",      // IPv6 link-local addr
 	}
 	for _, cidr := range localIPRanges {
-		_, block, err := net.ParseCIDR(cidr)
+		block, err := parsePrefix(cidr)
 		if err != nil {
 			resolver.logger.ErrorContext(
 				ctx,
@@ -1,6 +1,7 @@
 package traefik_real_ip
 
 import (
+	"net/netip"
 	"testing"
 )
 
@@ -34,9 +35,9 @@ func TestGetLocalIPsSingleton(t *testing.T) {
 	if len(ips1) > 0 && len(ips2) > 0 {
 		original := ips1[0]
 
-		ips1[0] = nil
+		ips1[0] = netip.Prefix{}
 
-		if ips2[0] != nil {
+		if ips2[0].IsValid() {
 			t.Error("Expected both slices to reference the same underlying array")
 		}
 
@@ -3,19 +3,39 @@ package traefik_real_ip
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"runtime/debug"
 	"strings"
+	"sync"
+)
+
+var (
+	logOutputMu sync.Mutex
+	logOutput   io.Writer = os.Stdout
 )
 
 func init() {
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+	slog.SetDefault(newSlogLogger(os.Stdout, slog.LevelDebug))
+}
+
+// SetLogOutput sets where the middleware logs, standard output by default.
+// It applies to loggers created afterwards and to the slog default.
+func SetLogOutput(out io.Writer) {
+	logOutputMu.Lock()
+	logOutput = out
+	logOutputMu.Unlock()
+
+	slog.SetDefault(newSlogLogger(out, slog.LevelDebug))
+}
+
+func newSlogLogger(out io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{
 		AddSource:   false,
-		Level:       slog.LevelDebug,
+		Level:       level,
 		ReplaceAttr: replaceAttr,
 	}))
-	slog.SetDefault(logger)
 }
 
 type PluginLogger struct {
@@ -25,6 +45,7 @@ type PluginLogger struct {
 
 func NewPluginLogger(ctx context.Context, pluginName, logLevelStr string) *PluginLogger {
 	logLevel := &slog.LevelVar{}
+	validLevel := true
 
 	switch strings.ToLower(logLevelStr) {
 	case LogLevelDebug:
@@ -38,27 +59,32 @@ func NewPluginLogger(ctx context.Context, pluginName, logLevelStr string) *Plugi
 	case "":
 		logLevel.Set(slog.LevelInfo)
 	default:
-		slog.WarnContext(
-			ctx,
-			"Invalid log level, using info",
-			slog.String("level", logLevelStr),
-		)
+		validLevel = false
+
 		logLevel.Set(slog.LevelInfo)
 	}
 
-	opts := &slog.HandlerOptions{
-		AddSource:   false,
-		Level:       logLevel,
-		ReplaceAttr: replaceAttr,
-	}
+	logOutputMu.Lock()
+	out := logOutput
+	logOutputMu.Unlock()
 
-	l := slog.New(slog.NewTextHandler(os.Stdout, opts))
+	l := newSlogLogger(out, logLevel)
 	slog.SetDefault(l)
 
-	return &PluginLogger{
+	logger := &PluginLogger{
 		logger:     l,
 		pluginName: pluginName,
 	}
+
+	if !validLevel {
+		logger.WarnContext(
+			ctx,
+			"Invalid log level, using info",
+			slog.String("level", logLevelStr),
+		)
+	}
+
+	return logger
 }
 
 func replaceAttr(_ []string, attr slog.Attr) slog.Attr {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"log/slog"
+	"os"
 	"strings"
 	"testing"
 )
@@ -133,3 +134,17 @@ func TestReplaceAttr_NonErrorAny(t *testing.T) {
 		t.Errorf("expected key %q, got %q", "key", replaced.Key)
 	}
 }
+
+func TestSetLogOutput(t *testing.T) {
+	var buf bytes.Buffer
+
+	SetLogOutput(&buf)
+	t.Cleanup(func() { SetLogOutput(os.Stdout) })
+
+	NewPluginLogger(t.Context(), "test-plugin", "invalid-level")
+
+	logs := buf.String()
+	if !strings.Contains(logs, "Invalid log level") || !strings.Contains(logs, "plugin=test-plugin") {
+		t.Errorf("expected the invalid level warning in the configured output, got %q", logs)
+	}
+}
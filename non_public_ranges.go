@@ -0,0 +1,152 @@
+package traefik_real_ip
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+var ErrInvalidNonPublicRanges = errors.New("invalid non-public ranges")
+
+// nonPublicCategories lists the entries of the IANA IPv4 and IPv6
+// special-purpose address registries that are not globally reachable,
+// grouped by category. IPv4-mapped addresses are unmapped before lookup.
+var nonPublicCategories = []struct {
+	name   string
+	ranges []string
+}{
+	{name: NonPublicUnspecified, ranges: []string{"0.0.0.0/32", "::/128"}},
+	{name: NonPublicThisNetwork, ranges: []string{"0.0.0.0/8"}},
+	{name: NonPublicLoopback, ranges: []string{"127.0.0.0/8", "::1/128"}},
+	{name: NonPublicPrivate, ranges: []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}},
+	{name: NonPublicUniqueLocal, ranges: []string{"fc00::/7"}},
+	{name: NonPublicSharedAddressSpace, ranges: []string{"100.64.0.0/10"}},
+	{name: NonPublicLinkLocal, ranges: []string{"169.254.0.0/16", "fe80::/10"}},
+	{name: NonPublicProtocolAssignments, ranges: []string{"192.0.0.0/24", "2001::/23"}},
+	{
+		name: NonPublicDocumentation,
+		ranges: []string{
+			"192.0.2.0/24", "198.51.100.0/24", "203.0.113.0/24", "2001:db8::/32", "3fff::/20",
+		},
+	},
+	{name: NonPublicBenchmarking, ranges: []string{"198.18.0.0/15", "2001:2::/48"}},
+	{name: NonPublicMulticast, ranges: []string{"224.0.0.0/4", "ff00::/8"}},
+	// 240.0.0.0/4 includes the limited broadcast address; 192.88.99.0/24 is
+	// the deprecated 6to4 relay anycast.
+	{name: NonPublicReserved, ranges: []string{"240.0.0.0/4", "192.88.99.0/24"}},
+	{name: NonPublicDiscard, ranges: []string{"100::/64", "100:0:0:1::/64"}},
+	{name: NonPublicTranslation, ranges: []string{"64:ff9b:1::/48"}},
+	{name: NonPublicSegmentRouting, ranges: []string{"5f00::/16"}},
+}
+
+// globallyReachableRanges are registry entries nested in the ranges above
+// that are globally reachable, such as anycast services.
+var globallyReachableRanges = []string{
+	"192.0.0.9/32",    // Port Control Protocol anycast
+	"192.0.0.10/32",   // Traversal Using Relays around NAT anycast
+	"2001:1::1/128",   // Port Control Protocol anycast
+	"2001:1::2/128",   // Traversal Using Relays around NAT anycast
+	"2001:1::3/128",   // DNS-SD Service Registration Protocol anycast
+	"2001:3::/32",     // AMT
+	"2001:4:112::/48", // AS112-v6
+	"2001:20::/28",    // ORCHIDv2
+	"2001:30::/28",    // Drone Remote ID Protocol Entity Tags
+}
+
+// nonPublicTable holds the ranges of the enabled categories.
+type nonPublicTable struct {
+	categories map[netip.Prefix]string
+	ranges     []netip.Prefix
+	reachable  []netip.Prefix
+}
+
+var defaultNonPublicTable = newNonPublicTable(nil)
+
+func newNonPublicTable(disabled map[string]bool) *nonPublicTable {
+	table := &nonPublicTable{categories: make(map[netip.Prefix]string)}
+
+	for _, category := range nonPublicCategories {
+		if disabled[category.name] {
+			continue
+		}
+
+		for _, cidr := range category.ranges {
+			prefix := netip.MustParsePrefix(cidr)
+			table.ranges = append(table.ranges, prefix)
+			table.categories[prefix] = category.name
+		}
+	}
+
+	for _, cidr := range globallyReachableRanges {
+		table.reachable = append(table.reachable, netip.MustParsePrefix(cidr))
+	}
+
+	return table
+}
+
+// parseNonPublicRanges builds the table from the per-category switches of
+// the configuration. Every category is enabled unless set to false.
+func parseNonPublicRanges(config map[string]bool) (*nonPublicTable, error) {
+	if len(config) == 0 {
+		return defaultNonPublicTable, nil
+	}
+
+	disabled := make(map[string]bool, len(config))
+
+	for name, enabled := range config {
+		category, ok := nonPublicCategoryName(name)
+		if !ok {
+			return nil, fmt.Errorf(
+				"%w: unknown category %q, expected one of %s",
+				ErrInvalidNonPublicRanges, name, strings.Join(nonPublicCategoryNames(), ", "),
+			)
+		}
+
+		disabled[category] = !enabled
+	}
+
+	return newNonPublicTable(disabled), nil
+}
+
+// nonPublicCategoryName matches a category name case-insensitively.
+func nonPublicCategoryName(name string) (string, bool) {
+	for _, category := range nonPublicCategories {
+		if strings.EqualFold(category.name, strings.TrimSpace(name)) {
+			return category.name, true
+		}
+	}
+
+	return "", false
+}
+
+func nonPublicCategoryNames() []string {
+	names := make([]string, 0, len(nonPublicCategories))
+	for _, category := range nonPublicCategories {
+		names = append(names, category.name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// category returns the category of the first enabled range containing ip.
+func (table *nonPublicTable) category(ip netip.Addr) (string, bool) {
+	ip = ip.Unmap()
+
+	for _, prefix := range table.reachable {
+		if prefix.Contains(ip) {
+			return "", false
+		}
+	}
+
+	for _, prefix := range table.ranges {
+		if prefix.Contains(ip) {
+			return table.categories[prefix], true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,95 @@
+package traefik_real_ip
+
+import (
+	"errors"
+	"net/http"
+	"net/netip"
+	"testing"
+)
+
+func TestNonPublicTable_Category(t *testing.T) {
+	tests := map[string]string{
+		"0.0.0.0":          NonPublicUnspecified,
+		"::":               NonPublicUnspecified,
+		"0.1.2.3":          NonPublicThisNetwork,
+		"127.0.0.1":        NonPublicLoopback,
+		"::ffff:10.0.0.1":  NonPublicPrivate,
+		"fd00::1":          NonPublicUniqueLocal,
+		"100.64.0.1":       NonPublicSharedAddressSpace,
+		"169.254.1.1":      NonPublicLinkLocal,
+		"192.0.0.1":        NonPublicProtocolAssignments,
+		"2001::1":          NonPublicProtocolAssignments,
+		"198.51.100.1":     NonPublicDocumentation,
+		"3fff::1":          NonPublicDocumentation,
+		"198.19.255.255":   NonPublicBenchmarking,
+		"239.1.1.1":        NonPublicMulticast,
+		"ff02::1":          NonPublicMulticast,
+		"255.255.255.255":  NonPublicReserved,
+		"100::1":           NonPublicDiscard,
+		"64:ff9b:1::1":     NonPublicTranslation,
+		"5f00::1":          NonPublicSegmentRouting,
+		"8.8.8.8":          "",
+		"2606:4700::1":     "",
+		"192.0.0.9":        "",
+		"2001:4:112::1":    "",
+		"64:ff9b::808:808": "",
+	}
+
+	for ip, expected := range tests {
+		category, ok := defaultNonPublicTable.category(netip.MustParseAddr(ip))
+		if category != expected || ok != (expected != "") {
+			t.Errorf("%s: expected %q, got %q %v", ip, expected, category, ok)
+		}
+	}
+}
+
+func TestParseNonPublicRanges(t *testing.T) {
+	table, err := parseNonPublicRanges(map[string]bool{
+		"SharedAddressSpace": false,
+		"documentation":      false,
+		"private":            true,
+	})
+	if err != nil {
+		t.Fatalf("parseNonPublicRanges: %v", err)
+	}
+
+	for _, ip := range []string{"100.64.0.1", "192.0.2.1"} {
+		if _, ok := table.category(netip.MustParseAddr(ip)); ok {
+			t.Errorf("%s: expected the disabled category to be public", ip)
+		}
+	}
+
+	if _, ok := table.category(netip.MustParseAddr("10.0.0.1")); !ok {
+		t.Error("expected the other categories to stay enabled")
+	}
+
+	_, err = parseNonPublicRanges(map[string]bool{"cgnat": false})
+	if !errors.Is(err, ErrInvalidNonPublicRanges) {
+		t.Errorf("expected ErrInvalidNonPublicRanges, got %v", err)
+	}
+}
+
+func TestNew_NonPublicRanges(t *testing.T) {
+	resolver := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.TrustedIPs = []string{"10.0.0.0/8"}
+		cfg.NonPublicRanges = map[string]bool{NonPublicSharedAddressSpace: false}
+	})
+
+	req, _ := http.NewRequestWithContext(t.Context(), http.MethodGet, "/", http.NoBody)
+	req.Header.Set(XForwardedFor, "192.0.2.1, 100.64.0.1")
+
+	ip, err := resolver.getRealIP(t.Context(), netip.MustParseAddr("10.0.0.1"), req)
+	if err != nil || ip != netip.MustParseAddr("100.64.0.1") {
+		t.Errorf("expected the CGNAT address, got %v, %v", ip, err)
+	}
+
+	cfg := CreateConfig()
+	cfg.NonPublicRanges = map[string]bool{"unknown": true}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	_, err = New(t.Context(), next, cfg, "test")
+	if !errors.Is(err, ErrInvalidNonPublicRanges) {
+		t.Errorf("expected ErrInvalidNonPublicRanges, got %v", err)
+	}
+}
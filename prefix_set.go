@@ -0,0 +1,200 @@
+package traefik_real_ip
+
+import (
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// labeledPrefix is a prefix of the trust table with the sources that
+// contributed to it.
+type labeledPrefix struct {
+	labels map[string]bool
+	prefix netip.Prefix
+}
+
+// aggregatePrefixes canonicalises a set of labeled prefixes: host bits are
+// masked, duplicates removed, prefixes contained in another one collapsed
+// into it and adjacent prefixes merged into their common parent. A merged
+// prefix carries the union of the labels of the prefixes it replaces.
+func aggregatePrefixes(prefixes []netip.Prefix, labels map[netip.Prefix][]string) (
+	[]netip.Prefix,
+	map[netip.Prefix]string,
+) {
+	unique := make(map[netip.Prefix]*labeledPrefix, len(prefixes))
+
+	for _, prefix := range prefixes {
+		masked := prefix.Masked()
+
+		entry, ok := unique[masked]
+		if !ok {
+			entry = &labeledPrefix{prefix: masked, labels: make(map[string]bool)}
+			unique[masked] = entry
+		}
+
+		for _, label := range labels[prefix] {
+			entry.labels[label] = true
+		}
+	}
+
+	sorted := make([]*labeledPrefix, 0, len(unique))
+	for _, entry := range unique {
+		sorted = append(sorted, entry)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		left, right := sorted[i].prefix, sorted[j].prefix
+		if left.Addr() != right.Addr() {
+			return left.Addr().Less(right.Addr())
+		}
+
+		return left.Bits() < right.Bits()
+	})
+
+	stack := make([]*labeledPrefix, 0, len(sorted))
+
+	for _, entry := range sorted {
+		if len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if top.prefix.Overlaps(entry.prefix) {
+				// Sorting puts the containing prefix first.
+				mergeLabels(top, entry)
+
+				continue
+			}
+		}
+
+		stack = append(stack, entry)
+
+		for len(stack) >= 2 {
+			parent, ok := commonParent(stack[len(stack)-2].prefix, stack[len(stack)-1].prefix)
+			if !ok {
+				break
+			}
+
+			merged := &labeledPrefix{prefix: parent, labels: stack[len(stack)-2].labels}
+			mergeLabels(merged, stack[len(stack)-1])
+
+			stack = append(stack[:len(stack)-2], merged)
+		}
+	}
+
+	result := make([]netip.Prefix, 0, len(stack))
+	providers := make(map[netip.Prefix]string, len(stack))
+
+	for _, entry := range stack {
+		result = append(result, entry.prefix)
+
+		if len(entry.labels) == 0 {
+			continue
+		}
+
+		names := make([]string, 0, len(entry.labels))
+		for label := range entry.labels {
+			names = append(names, label)
+		}
+
+		sort.Strings(names)
+		providers[entry.prefix] = strings.Join(names, ",")
+	}
+
+	return result, providers
+}
+
+func mergeLabels(into, from *labeledPrefix) {
+	for label := range from.labels {
+		into.labels[label] = true
+	}
+}
+
+// commonParent reports whether first and second are the two halves of the
+// same prefix, and returns that prefix.
+func commonParent(first, second netip.Prefix) (netip.Prefix, bool) {
+	if first.Bits() != second.Bits() || first.Bits() == 0 {
+		return netip.Prefix{}, false
+	}
+
+	parent := netip.PrefixFrom(first.Addr(), first.Bits()-1).Masked()
+	if parent.Addr() != first.Addr() || lastAddr(first).Next() != second.Addr() {
+		return netip.Prefix{}, false
+	}
+
+	return parent, true
+}
+
+// excludePrefixes removes the excluded ranges from the trusted prefixes with
+// longest-prefix-wins semantics: an exclusion only applies to the parts of a
+// trusted prefix that it is at least as specific as, so a trusted prefix
+// nested in an exclusion stays trusted. Exclusions win ties. The remaining
+// parts of a trusted prefix keep its labels.
+func excludePrefixes(
+	prefixes []netip.Prefix,
+	labels map[netip.Prefix][]string,
+	excluded []netip.Prefix,
+) ([]netip.Prefix, map[netip.Prefix][]string) {
+	if len(excluded) == 0 {
+		return prefixes, labels
+	}
+
+	result := make([]netip.Prefix, 0, len(prefixes))
+	resultLabels := make(map[netip.Prefix][]string, len(labels))
+
+	for _, prefix := range prefixes {
+		masked := prefix.Masked()
+		pieces := []netip.Prefix{masked}
+
+		for _, exclusion := range excluded {
+			if exclusion.Bits() < masked.Bits() || !masked.Contains(exclusion.Addr()) {
+				continue
+			}
+
+			pieces = subtractPrefix(pieces, exclusion)
+		}
+
+		for _, piece := range pieces {
+			result = append(result, piece)
+			resultLabels[piece] = append(resultLabels[piece], labels[prefix]...)
+		}
+	}
+
+	return result, resultLabels
+}
+
+// subtractPrefix removes exclusion from a set of disjoint prefixes.
+func subtractPrefix(pieces []netip.Prefix, exclusion netip.Prefix) []netip.Prefix {
+	result := make([]netip.Prefix, 0, len(pieces))
+
+	for _, piece := range pieces {
+		switch {
+		case !piece.Overlaps(exclusion):
+			result = append(result, piece)
+		case exclusion.Bits() <= piece.Bits():
+			// The exclusion covers the whole piece.
+		default:
+			result = append(result, splitAround(piece, exclusion)...)
+		}
+	}
+
+	return result
+}
+
+// splitAround returns the prefixes covering outer except inner, which must
+// be strictly contained in outer.
+func splitAround(outer, inner netip.Prefix) []netip.Prefix {
+	result := make([]netip.Prefix, 0, inner.Bits()-outer.Bits())
+
+	for current := outer; current.Bits() < inner.Bits(); {
+		low := netip.PrefixFrom(current.Addr(), current.Bits()+1)
+		high := netip.PrefixFrom(lastAddr(low).Next(), current.Bits()+1)
+
+		if low.Contains(inner.Addr()) {
+			result = append(result, high)
+			current = low
+		} else {
+			result = append(result, low)
+			current = high
+		}
+	}
+
+	return result
+}
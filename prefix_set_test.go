@@ -0,0 +1,220 @@
+package traefik_real_ip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestAggregatePrefixes(t *testing.T) {
+	type source struct {
+		cidr  string
+		label string
+	}
+
+	tests := []struct {
+		name    string
+		sources []source
+		want    []string
+	}{
+		{
+			name:    "duplicates",
+			sources: []source{{"10.0.0.0/24", "custom"}, {"10.0.0.0/24", "local"}},
+			want:    []string{"10.0.0.0/24 custom,local"},
+		},
+		{
+			name:    "host bits",
+			sources: []source{{"10.0.0.1/24", "local"}},
+			want:    []string{"10.0.0.0/24 local"},
+		},
+		{
+			name:    "contained",
+			sources: []source{{"10.1.2.0/24", "local"}, {"10.0.0.0/8", "custom"}},
+			want:    []string{"10.0.0.0/8 custom,local"},
+		},
+		{
+			name: "adjacent",
+			sources: []source{
+				{"192.0.2.0/26", "cloudflare"},
+				{"192.0.2.64/26", "cloudflare"},
+				{"192.0.2.128/25", "edgeone"},
+			},
+			want: []string{"192.0.2.0/24 cloudflare,edgeone"},
+		},
+		{
+			name:    "adjacent but not siblings",
+			sources: []source{{"192.0.2.64/26", "custom"}, {"192.0.2.128/26", "custom"}},
+			want:    []string{"192.0.2.64/26 custom", "192.0.2.128/26 custom"},
+		},
+		{
+			name: "mixed families",
+			sources: []source{
+				{"2400:cb00::/33", "cloudflare"},
+				{"2400:cb00:8000::/33", "cloudflare"},
+				{"255.255.255.255/32", "custom"},
+				{"255.255.255.254/32", "custom"},
+				{"::/0", "custom"},
+			},
+			want: []string{"255.255.255.254/31 custom", "::/0 cloudflare,custom"},
+		},
+		{
+			name:    "unlabeled",
+			sources: []source{{"10.0.0.0/25", ""}, {"10.0.0.128/25", ""}},
+			want:    []string{"10.0.0.0/24 "},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefixes := make([]netip.Prefix, 0, len(tt.sources))
+			labels := make(map[netip.Prefix][]string)
+
+			for _, src := range tt.sources {
+				prefix := netip.MustParsePrefix(src.cidr)
+				prefixes = append(prefixes, prefix)
+
+				if src.label != "" {
+					labels[prefix] = append(labels[prefix], src.label)
+				}
+			}
+
+			result, providers := aggregatePrefixes(prefixes, labels)
+
+			got := make([]string, 0, len(result))
+			for _, prefix := range result {
+				got = append(got, prefix.String()+" "+providers[prefix])
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("aggregatePrefixes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetTrustedSources_Aggregates(t *testing.T) {
+	resolver := newTestResolver(t)
+	resolver.setTrustedSources(map[string][]netip.Prefix{
+		"custom": {netip.MustParsePrefix("10.0.0.0/25")},
+		"local":  {netip.MustParsePrefix("10.0.0.128/25"), netip.MustParsePrefix("10.0.0.0/24")},
+	})
+
+	if len(resolver.trustedIPNets) != 1 {
+		t.Fatalf("expected one aggregated prefix, got %v", resolver.trustedIPNets)
+	}
+
+	trusted, provider := resolver.matchTrustedIP(t.Context(), netip.MustParseAddr("10.0.0.200"))
+	if !trusted || provider != "custom,local" {
+		t.Errorf("expected a trusted match labeled custom,local, got %v %q", trusted, provider)
+	}
+
+	// Replacing one source rebuilds the aggregate from the remaining ones.
+	resolver.setTrustedSources(map[string][]netip.Prefix{"local": nil})
+
+	trusted, provider = resolver.matchTrustedIP(t.Context(), netip.MustParseAddr("10.0.0.200"))
+	if trusted {
+		t.Errorf("expected the local range to be removed, got provider %q", provider)
+	}
+}
+
+func TestIPResolver_ServeHTTP_TrustedSourceHeader(t *testing.T) {
+	resolver := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.TrustedIPs = []string{"10.0.0.0/8"}
+	})
+
+	tests := map[string]string{
+		"10.0.0.1":    "custom",
+		"203.0.113.1": "",
+	}
+
+	for remote, expected := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		req.RemoteAddr = remote + ":1234"
+		req.Header.Set(XTrustedSource, "spoofed")
+
+		resolver.ServeHTTP(httptest.NewRecorder(), req)
+
+		if got := req.Header.Get(XTrustedSource); got != expected {
+			t.Errorf("%s: expected %s %q, got %q", remote, XTrustedSource, expected, got)
+		}
+	}
+}
+
+func TestExcludePrefixes(t *testing.T) {
+	tests := []struct {
+		name     string
+		trusted  []string
+		excluded []string
+		want     []string
+	}{
+		{
+			name:     "hole",
+			trusted:  []string{"10.0.0.0/8"},
+			excluded: []string{"10.66.0.0/16"},
+			want: []string{
+				"10.0.0.0/10", "10.64.0.0/15", "10.67.0.0/16", "10.68.0.0/14",
+				"10.72.0.0/13", "10.80.0.0/12", "10.96.0.0/11", "10.128.0.0/9",
+			},
+		},
+		{
+			name:     "tie",
+			trusted:  []string{"10.0.0.0/24"},
+			excluded: []string{"10.0.0.0/24"},
+			want:     []string{},
+		},
+		{
+			name:     "less specific exclusion loses",
+			trusted:  []string{"10.66.1.0/24"},
+			excluded: []string{"10.66.0.0/16"},
+			want:     []string{"10.66.1.0/24"},
+		},
+		{
+			name:     "other family",
+			trusted:  []string{"2001:db8::/32"},
+			excluded: []string{"0.0.0.0/0"},
+			want:     []string{"2001:db8::/32"},
+		},
+		{
+			name:     "several exclusions",
+			trusted:  []string{"192.0.2.0/24"},
+			excluded: []string{"192.0.2.0/25", "192.0.2.192/26"},
+			want:     []string{"192.0.2.128/26"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trusted := make([]netip.Prefix, 0, len(tt.trusted))
+			for _, cidr := range tt.trusted {
+				trusted = append(trusted, netip.MustParsePrefix(cidr))
+			}
+
+			excluded := make([]netip.Prefix, 0, len(tt.excluded))
+			for _, cidr := range tt.excluded {
+				excluded = append(excluded, netip.MustParsePrefix(cidr))
+			}
+
+			labels := map[netip.Prefix][]string{trusted[0]: {"custom"}}
+
+			result, resultLabels := excludePrefixes(trusted, labels, excluded)
+
+			got := prefixStrings(result)
+			slices.Sort(got)
+
+			want := slices.Clone(tt.want)
+			slices.Sort(want)
+
+			if !slices.Equal(got, want) {
+				t.Errorf("excludePrefixes() = %v, want %v", got, want)
+			}
+
+			for _, piece := range result {
+				if !slices.Equal(resultLabels[piece], []string{"custom"}) {
+					t.Errorf("expected %s to keep its label, got %v", piece, resultLabels[piece])
+				}
+			}
+		})
+	}
+}
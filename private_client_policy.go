@@ -0,0 +1,83 @@
+package traefik_real_ip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+)
+
+var (
+	ErrInvalidPrivateClientPolicy = errors.New("invalid private client policy")
+	ErrPrivateClientIP            = errors.New("non-public client IP rejected")
+)
+
+// parsePrivateClientPolicy validates the configured policy. An empty policy
+// keeps the historical per-header behaviour, see privateClientPolicyFor.
+func parsePrivateClientPolicy(policy string) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(policy))
+
+	switch normalized {
+	case "", PrivateClientPolicyAccept, PrivateClientPolicySkip,
+		PrivateClientPolicySource, PrivateClientPolicyReject:
+		return normalized, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidPrivateClientPolicy, policy)
+	}
+}
+
+// privateClientPolicyFor returns the policy to apply to a non-public address
+// read from header. Without a configured policy, provider headers are taken
+// as is and the other headers are skipped.
+func (resolver *IPResolver) privateClientPolicyFor(header string) string {
+	if resolver.privateClient != "" {
+		return resolver.privateClient
+	}
+
+	if header == CfConnectingIP || header == EoConnectingIP {
+		return PrivateClientPolicyAccept
+	}
+
+	return PrivateClientPolicySkip
+}
+
+// applyPrivateClientPolicy decides what to do with the client IP read from
+// header. It returns the address to use and whether the search is over; a
+// skipped address moves the search on to the next entry or header.
+func (resolver *IPResolver) applyPrivateClientPolicy(
+	ctx context.Context,
+	header string,
+	ip netip.Addr,
+	srcIP netip.Addr,
+) (netip.Addr, bool, error) {
+	category, nonPublic := resolver.nonPublicCategory(ip)
+	if !nonPublic {
+		return ip, true, nil
+	}
+
+	policy := resolver.privateClientPolicyFor(header)
+
+	resolver.logger.DebugContext(
+		ctx,
+		"Client IP is a non-public IP",
+		slog.String("header", header),
+		slog.String("ip", ip.String()),
+		slog.String("category", category),
+		slog.String("policy", policy),
+	)
+
+	switch policy {
+	case PrivateClientPolicyAccept:
+		return ip, true, nil
+	case PrivateClientPolicySource:
+		return srcIP, true, nil
+	case PrivateClientPolicyReject:
+		return netip.Addr{}, true, fmt.Errorf(
+			"%w in %s: %s (%s)", ErrPrivateClientIP, header, ip, category,
+		)
+	default:
+		return netip.Addr{}, false, nil
+	}
+}
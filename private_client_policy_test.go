@@ -0,0 +1,175 @@
+package traefik_real_ip
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestParsePrivateClientPolicy(t *testing.T) {
+	for _, policy := range []string{
+		"", PrivateClientPolicyAccept, PrivateClientPolicySkip,
+		PrivateClientPolicySource, PrivateClientPolicyReject,
+	} {
+		parsed, err := parsePrivateClientPolicy(policy)
+		if err != nil || parsed != policy {
+			t.Errorf("%q: expected it to be accepted, got %q, %v", policy, parsed, err)
+		}
+	}
+
+	parsed, err := parsePrivateClientPolicy(" Accept ")
+	if err != nil || parsed != PrivateClientPolicyAccept {
+		t.Errorf("expected the policy to be normalized, got %q, %v", parsed, err)
+	}
+
+	_, err = parsePrivateClientPolicy("fallback")
+	if !errors.Is(err, ErrInvalidPrivateClientPolicy) {
+		t.Errorf("expected ErrInvalidPrivateClientPolicy, got %v", err)
+	}
+}
+
+func privateClientPolicy(policy string) func(*Config) {
+	return func(cfg *Config) {
+		cfg.TrustedIPs = []string{"10.0.0.0/8"}
+		cfg.PrivateClientPolicy = policy
+	}
+}
+
+func TestIPResolver_PrivateClientPolicy(t *testing.T) {
+	srcIP := netip.MustParseAddr("10.0.0.1")
+
+	tests := []struct {
+		name       string
+		policy     string
+		headers    map[string]string
+		expectedIP string
+		rejected   bool
+		invalid    bool
+		malformed  bool
+	}{
+		{
+			name:       "Default accepts provider headers",
+			headers:    map[string]string{CfConnectingIP: "192.168.1.1"},
+			expectedIP: "192.168.1.1",
+		},
+		{
+			name:       "Default skips X-Real-IP",
+			headers:    map[string]string{XRealIP: "192.168.1.1", XForwardedFor: "9.9.9.9"},
+			expectedIP: "9.9.9.9",
+		},
+		{
+			name:    "Default fails when X-Forwarded-For has no public IP",
+			headers: map[string]string{XForwardedFor: "192.168.1.1"},
+			invalid: true,
+		},
+		{
+			name:       "Accept X-Forwarded-For",
+			policy:     PrivateClientPolicyAccept,
+			headers:    map[string]string{XForwardedFor: "192.168.1.1, 9.9.9.9"},
+			expectedIP: "192.168.1.1",
+		},
+		{
+			name:       "Accept X-Real-IP",
+			policy:     PrivateClientPolicyAccept,
+			headers:    map[string]string{XRealIP: "192.168.1.1", XForwardedFor: "9.9.9.9"},
+			expectedIP: "192.168.1.1",
+		},
+		{
+			name:       "Skip provider header to the next source",
+			policy:     PrivateClientPolicySkip,
+			headers:    map[string]string{CfConnectingIP: "192.168.1.1", XRealIP: "9.9.9.9"},
+			expectedIP: "9.9.9.9",
+		},
+		{
+			name:       "Skip every X-Forwarded-For entry to the source IP",
+			policy:     PrivateClientPolicySkip,
+			headers:    map[string]string{XForwardedFor: "192.168.1.1, 10.1.1.1"},
+			expectedIP: "10.0.0.1",
+		},
+		{
+			name:       "Source IP on a non-public provider header",
+			policy:     PrivateClientPolicySource,
+			headers:    map[string]string{EoConnectingIP: "192.168.1.1", XRealIP: "9.9.9.9"},
+			expectedIP: "10.0.0.1",
+		},
+		{
+			name:       "Source IP on a non-public X-Forwarded-For entry",
+			policy:     PrivateClientPolicySource,
+			headers:    map[string]string{XForwardedFor: "192.168.1.1, 9.9.9.9"},
+			expectedIP: "10.0.0.1",
+		},
+		{
+			name:     "Reject X-Forwarded-For",
+			policy:   PrivateClientPolicyReject,
+			headers:  map[string]string{XForwardedFor: "192.168.1.1, 9.9.9.9"},
+			rejected: true,
+		},
+		{
+			name:     "Reject provider header",
+			policy:   PrivateClientPolicyReject,
+			headers:  map[string]string{CfConnectingIP: "192.168.1.1"},
+			rejected: true,
+		},
+		{
+			name:       "Public addresses are unaffected",
+			policy:     PrivateClientPolicyReject,
+			headers:    map[string]string{XRealIP: "9.9.9.9"},
+			expectedIP: "9.9.9.9",
+		},
+		{
+			name:      "Invalid X-Forwarded-For still fails",
+			policy:    PrivateClientPolicySkip,
+			headers:   map[string]string{XForwardedFor: "invalid-ip"},
+			malformed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := newConfiguredResolver(t, privateClientPolicy(tt.policy))
+
+			req, _ := http.NewRequestWithContext(t.Context(), http.MethodGet, "/", http.NoBody)
+			for header, value := range tt.headers {
+				req.Header.Set(header, value)
+			}
+
+			ip, err := resolver.getRealIP(t.Context(), srcIP, req)
+
+			switch {
+			case tt.rejected:
+				if !errors.Is(err, ErrPrivateClientIP) {
+					t.Errorf("expected ErrPrivateClientIP, got %v", err)
+				}
+			case tt.malformed:
+				if !errors.Is(err, ErrXForwardedForInvalid) {
+					t.Errorf("expected ErrXForwardedForInvalid, got %v", err)
+				}
+			case tt.invalid:
+				if !errors.Is(err, ErrNoValidIPInXForwardedFor) {
+					t.Errorf("expected ErrNoValidIPInXForwardedFor, got %v", err)
+				}
+			case err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case ip.String() != tt.expectedIP:
+				t.Errorf("expected %s, got %s", tt.expectedIP, ip)
+			}
+		})
+	}
+}
+
+func TestIPResolver_ServeHTTP_PrivateClientRejected(t *testing.T) {
+	resolver := newConfiguredResolver(t, privateClientPolicy(PrivateClientPolicyReject))
+
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set(XForwardedFor, "192.168.1.1")
+
+	rec := httptest.NewRecorder()
+	resolver.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected status 403, got %d", rec.Code)
+	}
+}
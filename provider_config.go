@@ -0,0 +1,281 @@
+package traefik_real_ip
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidProviderConfig = errors.New("invalid provider configuration")
+
+const (
+	defaultRetryMaxDelay   = 10 * time.Second
+	defaultRetryBudget     = 30 * time.Second
+	defaultMaxBodySize     = 1 << 20
+	defaultMinPrefixLength = 8
+	defaultContentType     = "text/plain"
+)
+
+// retryPolicy controls how provider fetches are retried.
+type retryPolicy struct {
+	initialDelay time.Duration
+	maxDelay     time.Duration
+	budget       time.Duration
+	maxRetries   int
+}
+
+// providerGuards are the sanity checks applied to a provider response
+// before its ranges are trusted. Zero values disable a check.
+type providerGuards struct {
+	contentTypes    []string
+	maxShrinkRatio  float64
+	maxBodySize     int64
+	minCIDRs        int
+	minPrefixLength int
+}
+
+// providerSettings is the validated form of a ProviderConfig.
+type providerSettings struct {
+	signatureKey ed25519.PublicKey
+	format       string
+	urls         []string
+	guards       providerGuards
+	retry        retryPolicy
+}
+
+func defaultProviderSettings() providerSettings {
+	return providerSettings{
+		retry: retryPolicy{
+			initialDelay: initialRetryDelay,
+			maxDelay:     defaultRetryMaxDelay,
+			budget:       defaultRetryBudget,
+			maxRetries:   maxRetries,
+		},
+		guards: providerGuards{
+			contentTypes:    []string{defaultContentType},
+			maxBodySize:     defaultMaxBodySize,
+			minPrefixLength: defaultMinPrefixLength,
+		},
+	}
+}
+
+// parseProviderConfig validates a ProviderConfig, filling unset values with
+// the defaults.
+func parseProviderConfig(name string, config ProviderConfig) (providerSettings, error) {
+	settings := defaultProviderSettings()
+
+	durations := []struct {
+		target *time.Duration
+		field  string
+		value  string
+	}{
+		{
+			target: &settings.retry.initialDelay,
+			field:  "retryInitialDelay",
+			value:  config.RetryInitialDelay,
+		},
+		{
+			target: &settings.retry.maxDelay,
+			field:  "retryMaxDelay",
+			value:  config.RetryMaxDelay,
+		},
+		{
+			target: &settings.retry.budget,
+			field:  "retryBudget",
+			value:  config.RetryBudget,
+		},
+	}
+
+	for _, duration := range durations {
+		if duration.value == "" {
+			continue
+		}
+
+		parsed, err := time.ParseDuration(duration.value)
+		if err != nil || parsed < 0 {
+			return providerSettings{}, fmt.Errorf(
+				"%w: %s.%s: %q",
+				ErrInvalidProviderConfig, name, duration.field, duration.value,
+			)
+		}
+
+		*duration.target = parsed
+	}
+
+	switch {
+	case config.MaxRetries < 0:
+		settings.retry.maxRetries = 0
+	case config.MaxRetries > 0:
+		settings.retry.maxRetries = config.MaxRetries
+	}
+
+	if settings.retry.maxDelay < settings.retry.initialDelay {
+		settings.retry.maxDelay = settings.retry.initialDelay
+	}
+
+	guards, err := parseProviderGuards(name, config, settings.guards)
+	if err != nil {
+		return providerSettings{}, err
+	}
+
+	settings.guards = guards
+
+	for _, rawURL := range config.URLs {
+		_, isFile, err := fileURLPath(rawURL)
+		if isFile {
+			if err != nil {
+				return providerSettings{}, fmt.Errorf("%w: %s.urls: %w", ErrInvalidProviderConfig, name, err)
+			}
+
+			settings.urls = append(settings.urls, rawURL)
+
+			continue
+		}
+
+		parsed, err := url.Parse(rawURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return providerSettings{}, fmt.Errorf(
+				"%w: %s.urls: %q", ErrInvalidProviderConfig, name, redactURL(rawURL),
+			)
+		}
+
+		settings.urls = append(settings.urls, rawURL)
+	}
+
+	settings.format, err = parseListFormat(config.Format)
+	if err != nil {
+		return providerSettings{}, fmt.Errorf("%w: %s.format: %w", ErrInvalidProviderConfig, name, err)
+	}
+
+	settings.signatureKey, err = parseSignatureKey(name, config.SignaturePublicKey)
+	if err != nil {
+		return providerSettings{}, err
+	}
+
+	return settings, nil
+}
+
+// parseProviderGuards applies the guard options of config on top of the
+// defaults. Negative sizes and lengths, or a "*" content type, disable the
+// corresponding check.
+func parseProviderGuards(
+	name string,
+	config ProviderConfig,
+	guards providerGuards,
+) (providerGuards, error) {
+	if config.MaxShrinkRatio < 0 || config.MaxShrinkRatio > 1 {
+		return providerGuards{}, fmt.Errorf(
+			"%w: %s.maxShrinkRatio must be between 0 and 1: %v",
+			ErrInvalidProviderConfig, name, config.MaxShrinkRatio,
+		)
+	}
+
+	if config.MinPrefixLength > 128 {
+		return providerGuards{}, fmt.Errorf(
+			"%w: %s.minPrefixLength must be at most 128: %d",
+			ErrInvalidProviderConfig, name, config.MinPrefixLength,
+		)
+	}
+
+	guards.maxShrinkRatio = config.MaxShrinkRatio
+	guards.minCIDRs = max(config.MinCIDRs, 0)
+
+	switch {
+	case config.MaxBodySize < 0:
+		guards.maxBodySize = 0
+	case config.MaxBodySize > 0:
+		guards.maxBodySize = config.MaxBodySize
+	}
+
+	switch {
+	case config.MinPrefixLength < 0:
+		guards.minPrefixLength = 0
+	case config.MinPrefixLength > 0:
+		guards.minPrefixLength = config.MinPrefixLength
+	}
+
+	if len(config.AllowedContentTypes) > 0 {
+		guards.contentTypes = nil
+
+		for _, contentType := range config.AllowedContentTypes {
+			contentType = strings.ToLower(strings.TrimSpace(contentType))
+			if contentType == "*" {
+				guards.contentTypes = nil
+
+				break
+			}
+
+			guards.contentTypes = append(guards.contentTypes, contentType)
+		}
+	}
+
+	return guards, nil
+}
+
+// providerSettingsFor returns the settings configured for the named provider,
+// or the defaults when none were configured.
+func (resolver *IPResolver) providerSettingsFor(name string) providerSettings {
+	settings, ok := resolver.providerSettings[name]
+	if !ok {
+		return defaultProviderSettings()
+	}
+
+	return settings
+}
+
+// backoff returns the delay before the given retry attempt (starting at 1)
+// using exponential backoff with full jitter, capped at maxDelay.
+func (policy retryPolicy) backoff(attempt int) time.Duration {
+	ceiling := policy.initialDelay
+	for i := 1; i < attempt && ceiling < policy.maxDelay; i++ {
+		ceiling *= 2
+	}
+
+	ceiling = min(ceiling, policy.maxDelay)
+	if ceiling <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an
+// HTTP date. It returns false when the header is missing or invalid.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	return max(date.Sub(now), 0), true
+}
+
+// isRetryableStatus reports whether a non-OK status is worth retrying. Client
+// errors are final, except 429 Too Many Requests.
+func isRetryableStatus(statusCode int) bool {
+	if statusCode == http.StatusTooManyRequests {
+		return true
+	}
+
+	return statusCode < http.StatusBadRequest || statusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,131 @@
+package traefik_real_ip
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseProviderConfig(t *testing.T) {
+	settings, err := parseProviderConfig("test", ProviderConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if settings.retry != defaultProviderSettings().retry {
+		t.Errorf("expected default retry policy, got %+v", settings.retry)
+	}
+
+	settings, err = parseProviderConfig("test", ProviderConfig{
+		RetryInitialDelay: "100ms",
+		RetryMaxDelay:     "1s",
+		RetryBudget:       "5s",
+		MaxRetries:        3,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := retryPolicy{
+		initialDelay: 100 * time.Millisecond,
+		maxDelay:     time.Second,
+		budget:       5 * time.Second,
+		maxRetries:   3,
+	}
+	if settings.retry != expected {
+		t.Errorf("expected %+v, got %+v", expected, settings.retry)
+	}
+
+	settings, err = parseProviderConfig("test", ProviderConfig{MaxRetries: -1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if settings.retry.maxRetries != 0 {
+		t.Errorf("expected negative maxRetries to disable retries, got %d", settings.retry.maxRetries)
+	}
+
+	_, err = parseProviderConfig("test", ProviderConfig{RetryBudget: "soon"})
+	if !errors.Is(err, ErrInvalidProviderConfig) {
+		t.Errorf("expected ErrInvalidProviderConfig, got %v", err)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := retryPolicy{initialDelay: 100 * time.Millisecond, maxDelay: time.Second}
+
+	ceilings := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+
+	for i, ceiling := range ceilings {
+		for range 50 {
+			delay := policy.backoff(i + 1)
+			if delay < 0 || delay > ceiling {
+				t.Fatalf("attempt %d: delay %s outside [0, %s]", i+1, delay, ceiling)
+			}
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{name: "empty", value: ""},
+		{name: "seconds", value: "120", expected: 2 * time.Minute, ok: true},
+		{name: "negative", value: "-1"},
+		{
+			name:     "http date",
+			value:    now.Add(30 * time.Second).Format(http.TimeFormat),
+			expected: 30 * time.Second,
+			ok:       true,
+		},
+		{name: "date in the past", value: now.Add(-time.Hour).Format(http.TimeFormat), ok: true},
+		{name: "garbage", value: "later"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, ok := parseRetryAfter(tt.value, now)
+			if ok != tt.ok || delay != tt.expected {
+				t.Errorf(
+					"parseRetryAfter(%q) = %s, %v; want %s, %v",
+					tt.value, delay, ok, tt.expected, tt.ok,
+				)
+			}
+		})
+	}
+}
+
+func TestIsRetryableStatus(t *testing.T) {
+	retryable := []int{
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+	}
+	for _, code := range retryable {
+		if !isRetryableStatus(code) {
+			t.Errorf("expected %d to be retryable", code)
+		}
+	}
+
+	final := []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound}
+	for _, code := range final {
+		if isRetryableStatus(code) {
+			t.Errorf("expected %d not to be retryable", code)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package traefik_real_ip
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/netip"
+)
+
+var (
+	ErrUnexpectedContentType = errors.New("unexpected provider response content type")
+	ErrResponseTooLarge      = errors.New("provider response too large")
+	ErrPrefixTooBroad        = errors.New("provider prefix too broad")
+	ErrTooFewCIDRs           = errors.New("provider returned too few CIDRs")
+	ErrProviderListShrunk    = errors.New("provider list shrank too much")
+)
+
+// checkContentType rejects responses whose media type is not allowed, such
+// as the HTML error page of an intercepting proxy. Responses without a
+// Content-Type header are accepted.
+func (guards providerGuards) checkContentType(resp *http.Response) error {
+	if len(guards.contentTypes) == 0 {
+		return nil
+	}
+
+	header := resp.Header.Get("Content-Type")
+	if header == "" {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnexpectedContentType, header)
+	}
+
+	for _, allowed := range guards.contentTypes {
+		if mediaType == allowed {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrUnexpectedContentType, mediaType)
+}
+
+// checkRanges validates a freshly parsed list against the configured limits
+// and, when available, the previous good list of the same URL.
+func (guards providerGuards) checkRanges(ips, previous []netip.Prefix) error {
+	if guards.minPrefixLength > 0 {
+		for _, prefix := range ips {
+			if prefix.Bits() < guards.minPrefixLength {
+				return fmt.Errorf(
+					"%w: %s is shorter than /%d",
+					ErrPrefixTooBroad, prefix, guards.minPrefixLength,
+				)
+			}
+		}
+	}
+
+	if len(ips) < guards.minCIDRs {
+		return fmt.Errorf("%w: got %d, want at least %d", ErrTooFewCIDRs, len(ips), guards.minCIDRs)
+	}
+
+	if guards.maxShrinkRatio > 0 && len(previous) > 0 {
+		shrink := 1 - float64(len(ips))/float64(len(previous))
+		if shrink > guards.maxShrinkRatio {
+			return fmt.Errorf(
+				"%w: %d ranges down from %d",
+				ErrProviderListShrunk, len(ips), len(previous),
+			)
+		}
+	}
+
+	return nil
+}
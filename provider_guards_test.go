@@ -0,0 +1,215 @@
+package traefik_real_ip
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestProviderGuards_CheckContentType(t *testing.T) {
+	guards := defaultProviderSettings().guards
+
+	tests := []struct {
+		name        string
+		contentType string
+		wantErr     bool
+	}{
+		{name: "missing", contentType: ""},
+		{name: "plain", contentType: "text/plain"},
+		{name: "with charset", contentType: "text/plain; charset=utf-8"},
+		{name: "html", contentType: "text/html", wantErr: true},
+		{name: "malformed", contentType: "text/plain;;=", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tt.contentType != "" {
+				resp.Header.Set("Content-Type", tt.contentType)
+			}
+
+			err := guards.checkContentType(resp)
+			if tt.wantErr != errors.Is(err, ErrUnexpectedContentType) {
+				t.Errorf("checkContentType(%q) = %v, wantErr %v", tt.contentType, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProviderGuards_CheckRanges(t *testing.T) {
+	prefixes := func(cidrs ...string) []netip.Prefix {
+		result := make([]netip.Prefix, 0, len(cidrs))
+		for _, cidr := range cidrs {
+			result = append(result, netip.MustParsePrefix(cidr))
+		}
+
+		return result
+	}
+
+	previous := prefixes("10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/24", "10.0.3.0/24")
+
+	tests := []struct {
+		name     string
+		guards   providerGuards
+		ips      []netip.Prefix
+		previous []netip.Prefix
+		wantErr  error
+	}{
+		{
+			name:   "defaults accept a normal list",
+			guards: defaultProviderSettings().guards,
+			ips:    prefixes("173.245.48.0/20", "2400:cb00::/32"),
+		},
+		{
+			name:    "catch-all prefix",
+			guards:  defaultProviderSettings().guards,
+			ips:     prefixes("173.245.48.0/20", "0.0.0.0/0"),
+			wantErr: ErrPrefixTooBroad,
+		},
+		{
+			name:    "broad IPv6 prefix",
+			guards:  providerGuards{minPrefixLength: 16},
+			ips:     prefixes("2400::/12"),
+			wantErr: ErrPrefixTooBroad,
+		},
+		{
+			name:   "prefix guard disabled",
+			guards: providerGuards{},
+			ips:    prefixes("0.0.0.0/0"),
+		},
+		{
+			name:    "too few CIDRs",
+			guards:  providerGuards{minCIDRs: 3},
+			ips:     prefixes("10.0.0.0/24", "10.0.1.0/24"),
+			wantErr: ErrTooFewCIDRs,
+		},
+		{
+			name:     "shrunk",
+			guards:   providerGuards{maxShrinkRatio: 0.5},
+			ips:      prefixes("10.0.0.0/24"),
+			previous: previous,
+			wantErr:  ErrProviderListShrunk,
+		},
+		{
+			name:     "shrunk within ratio",
+			guards:   providerGuards{maxShrinkRatio: 0.5},
+			ips:      prefixes("10.0.0.0/24", "10.0.1.0/24"),
+			previous: previous,
+		},
+		{
+			name:   "shrink without previous list",
+			guards: providerGuards{maxShrinkRatio: 0.5},
+			ips:    prefixes("10.0.0.0/24"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.guards.checkRanges(tt.ips, tt.previous)
+
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestGetProviderIPsFromURL_Guards(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantErr     error
+	}{
+		{
+			name:        "HTML page",
+			contentType: "text/html; charset=utf-8",
+			body:        "<html>login</html>",
+			wantErr:     ErrUnexpectedContentType,
+		},
+		{
+			name:        "oversized body",
+			contentType: "text/plain",
+			body:        strings.Repeat("173.245.48.0/20\n", 100000),
+			wantErr:     ErrResponseTooLarge,
+		},
+		{
+			name:        "catch-all prefix",
+			contentType: "text/plain",
+			body:        "173.245.48.0/20\n0.0.0.0/0\n",
+			wantErr:     ErrPrefixTooBroad,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", tt.contentType)
+					_, _ = w.Write([]byte(tt.body))
+				}),
+			)
+			defer server.Close()
+
+			resolver := newTestResolver(t)
+
+			ips, err := resolver.getProviderIPsFromURL(t.Context(), "test", server.URL)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+
+			if ips != nil {
+				t.Errorf("expected no ranges, got %v", ips)
+			}
+		})
+	}
+}
+
+func TestFetchProviderIPs_KeepsLastGoodListOnGuardFailure(t *testing.T) {
+	var poisoned atomic.Bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+
+		if poisoned.Load() {
+			_, _ = w.Write([]byte("173.245.48.0/20\n"))
+
+			return
+		}
+
+		_, _ = w.Write([]byte("173.245.48.0/20\n103.21.244.0/22\n103.22.200.0/22\n"))
+	}))
+	defer server.Close()
+
+	resolver := newTestResolver(t)
+	resolver.providerSettings["test"] = providerSettings{
+		retry:  resolver.providerSettingsFor("test").retry,
+		guards: providerGuards{maxShrinkRatio: 0.5},
+	}
+
+	provider := remoteIPProvider{name: "test", urls: []string{server.URL}}
+
+	first, _ := resolver.fetchProviderIPs(t.Context(), provider)
+	if len(first) != 3 {
+		t.Fatalf("expected 3 ranges, got %v", first)
+	}
+
+	poisoned.Store(true)
+
+	second, complete := resolver.fetchProviderIPs(t.Context(), provider)
+	if len(second) != len(first) {
+		t.Errorf("expected the last good list to be kept, got %v", second)
+	}
+
+	if complete {
+		t.Error("expected a fallback to the last good list to be degraded")
+	}
+}
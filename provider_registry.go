@@ -0,0 +1,269 @@
+package traefik_real_ip
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// providerIdleTTL is how long an unreferenced entry is kept so that
+	// instances recreated on a configuration reload reuse it.
+	providerIdleTTL = 10 * time.Minute
+	// providerMaxAge is how long a complete result is reused before the next
+	// lookup revalidates it, with a conditional request where possible.
+	providerMaxAge = 15 * time.Minute
+	// providerNegativeTTL is how long an empty or degraded result is reused
+	// before the next lookup fetches again.
+	providerNegativeTTL = time.Minute
+)
+
+// providerLoader fetches the ranges of a single provider. It reports whether
+// the result is complete, i.e. no URL of the provider failed.
+type providerLoader func(ctx context.Context) ([]netip.Prefix, bool)
+
+type providerEntry struct {
+	loadedAt  time.Time
+	idleSince time.Time
+	ips       []netip.Prefix
+	refs      int
+	loaded    bool
+	complete  bool
+}
+
+// providerRegistry shares provider ranges between middleware instances.
+// Entries are keyed by provider name, URL set and variant, concurrent fetches of the
+// same key are deduplicated, and an entry is dropped once no instance has
+// held a reference to it for idleTTL.
+type providerRegistry struct {
+	entries     map[string]*providerEntry
+	urls        map[string]urlCacheEntry
+	group       singleflight.Group
+	idleTTL     time.Duration
+	maxAge      time.Duration
+	negativeTTL time.Duration
+	mu          sync.Mutex
+}
+
+var defaultProviderRegistry = newProviderRegistry()
+
+func newProviderRegistry() *providerRegistry {
+	return &providerRegistry{
+		entries:     make(map[string]*providerEntry),
+		urls:        make(map[string]urlCacheEntry),
+		idleTTL:     providerIdleTTL,
+		maxAge:      providerMaxAge,
+		negativeTTL: providerNegativeTTL,
+	}
+}
+
+func providerKey(name string, urls []string) string {
+	return name + "|" + strings.Join(urls, ",")
+}
+
+// providerVariant identifies the settings deciding which lists an instance
+// accepts from a provider: signature key, guards, format and HTTP client.
+// Instances only share registry and URL cache entries with the same variant,
+// so that a list fetched under laxer settings is never reused.
+func (resolver *IPResolver) providerVariant(name string) string {
+	settings := resolver.providerSettingsFor(name)
+
+	hash := sha256.New()
+	_, _ = fmt.Fprintf(
+		hash,
+		"%x|%s|%v|%s",
+		[]byte(settings.signatureKey),
+		settings.format,
+		settings.guards,
+		resolver.providerHTTPClient().fingerprint,
+	)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// urlCacheKey keys the last good response of url for the given provider.
+func (resolver *IPResolver) urlCacheKey(name, url string) string {
+	return resolver.providerVariant(name) + "|" + url
+}
+
+// lookup returns the ranges cached for key, loading them when no instance has
+// fetched them yet. Each call takes a reference on key that must be dropped
+// with release.
+//
+// The load runs on a context detached from ctx so that a canceled caller
+// cannot poison the shared result for every other instance. Complete results
+// are reused for maxAge, empty or degraded ones only for negativeTTL, after
+// which the next lookup tries again.
+func (registry *providerRegistry) lookup(
+	ctx context.Context,
+	key string,
+	load providerLoader,
+) []netip.Prefix {
+	registry.mu.Lock()
+
+	now := time.Now()
+	registry.prune(now)
+
+	entry, ok := registry.entries[key]
+	if !ok {
+		entry = &providerEntry{}
+		registry.entries[key] = entry
+	}
+
+	entry.refs++
+
+	if registry.fresh(entry, now) {
+		ips := entry.ips
+		registry.mu.Unlock()
+
+		return ips
+	}
+
+	registry.mu.Unlock()
+
+	result, _, _ := registry.group.Do(key, func() (any, error) {
+		// Another caller may have completed the load between our check
+		// above and joining the flight.
+		registry.mu.Lock()
+		if current, ok := registry.entries[key]; ok && registry.fresh(current, time.Now()) {
+			ips := current.ips
+			registry.mu.Unlock()
+
+			return ips, nil
+		}
+		registry.mu.Unlock()
+
+		ips, complete := load(context.WithoutCancel(ctx))
+
+		registry.mu.Lock()
+		defer registry.mu.Unlock()
+
+		current, ok := registry.entries[key]
+		if ok {
+			current.ips = ips
+			current.loaded = true
+			current.complete = complete
+			current.loadedAt = time.Now()
+		}
+
+		return ips, nil
+	})
+
+	ips, _ := result.([]netip.Prefix)
+
+	return ips
+}
+
+// fresh reports whether entry can be reused without loading it again. The
+// caller must hold registry.mu.
+func (registry *providerRegistry) fresh(entry *providerEntry, now time.Time) bool {
+	if !entry.loaded {
+		return false
+	}
+
+	age := now.Sub(entry.loadedAt)
+	if entry.complete && len(entry.ips) > 0 {
+		return age < registry.maxAge
+	}
+
+	return age < registry.negativeTTL
+}
+
+// release drops a reference taken by lookup.
+func (registry *providerRegistry) release(key string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	entry, ok := registry.entries[key]
+	if !ok {
+		return
+	}
+
+	entry.refs--
+	if entry.refs > 0 {
+		return
+	}
+
+	entry.refs = 0
+	entry.idleSince = time.Now()
+
+	registry.prune(entry.idleSince)
+}
+
+// prune drops entries that have been unreferenced for idleTTL. The caller
+// must hold registry.mu.
+func (registry *providerRegistry) prune(now time.Time) {
+	for key, entry := range registry.entries {
+		if entry.refs == 0 && now.Sub(entry.idleSince) >= registry.idleTTL {
+			delete(registry.entries, key)
+		}
+	}
+}
+
+func (registry *providerRegistry) refs(key string) int {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	entry, ok := registry.entries[key]
+	if !ok {
+		return 0
+	}
+
+	return entry.refs
+}
+
+func (resolver *IPResolver) providerRegistry() *providerRegistry {
+	if resolver.registry == nil {
+		return defaultProviderRegistry
+	}
+
+	return resolver.registry
+}
+
+// lookupProvider resolves a provider through the resolver's registry and
+// remembers the reference so it can be released with the instance.
+func (resolver *IPResolver) lookupProvider(
+	ctx context.Context,
+	key string,
+	load providerLoader,
+) []netip.Prefix {
+	registry := resolver.providerRegistry()
+
+	ips := registry.lookup(ctx, key, load)
+
+	resolver.providerMu.Lock()
+	defer resolver.providerMu.Unlock()
+
+	// A background load can finish after the instance was released.
+	if resolver.released {
+		registry.release(key)
+
+		return ips
+	}
+
+	resolver.providerKeys = append(resolver.providerKeys, key)
+
+	return ips
+}
+
+// releaseProviders drops every registry reference held by the resolver.
+func (resolver *IPResolver) releaseProviders() {
+	registry := resolver.providerRegistry()
+
+	resolver.providerMu.Lock()
+	keys := resolver.providerKeys
+	resolver.providerKeys = nil
+	resolver.released = true
+	resolver.providerMu.Unlock()
+
+	for _, key := range keys {
+		registry.release(key)
+	}
+}
@@ -0,0 +1,279 @@
+package traefik_real_ip
+
+import (
+	"context"
+	"net/http"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProviderRegistry_DeduplicatesConcurrentLoads(t *testing.T) {
+	registry := newProviderRegistry()
+	key := providerKey("test", []string{"http://example.com"})
+
+	var loads atomic.Int32
+
+	release := make(chan struct{})
+	load := func(_ context.Context) ([]netip.Prefix, bool) {
+		loads.Add(1)
+		<-release
+
+		return []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, true
+	}
+
+	const callers = 8
+
+	var wg sync.WaitGroup
+
+	results := make([][]netip.Prefix, callers)
+
+	for i := range callers {
+		wg.Go(func() {
+			results[i] = registry.lookup(t.Context(), key, load)
+		})
+	}
+
+	waitFor(t, func() bool { return registry.refs(key) == callers })
+	close(release)
+	wg.Wait()
+
+	if loads.Load() != 1 {
+		t.Errorf("expected a single load, got %d", loads.Load())
+	}
+
+	for i, ips := range results {
+		if len(ips) != 1 {
+			t.Errorf("caller %d: expected 1 range, got %d", i, len(ips))
+		}
+	}
+}
+
+func TestProviderRegistry_ReferenceCounting(t *testing.T) {
+	registry := newProviderRegistry()
+	registry.idleTTL = 0
+	key := providerKey("test", nil)
+
+	var loads atomic.Int32
+
+	load := func(_ context.Context) ([]netip.Prefix, bool) {
+		loads.Add(1)
+
+		return []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, true
+	}
+
+	registry.lookup(t.Context(), key, load)
+	registry.lookup(t.Context(), key, load)
+
+	if registry.refs(key) != 2 {
+		t.Fatalf("expected 2 references, got %d", registry.refs(key))
+	}
+
+	if loads.Load() != 1 {
+		t.Fatalf("expected cached result to be reused, got %d loads", loads.Load())
+	}
+
+	registry.release(key)
+	registry.release(key)
+
+	if registry.refs(key) != 0 {
+		t.Fatalf("expected entry to be dropped, got %d references", registry.refs(key))
+	}
+
+	if _, ok := registry.entries[key]; ok {
+		t.Fatal("expected idle entry to be pruned")
+	}
+
+	registry.lookup(t.Context(), key, load)
+
+	if loads.Load() != 2 {
+		t.Errorf("expected reload after last release, got %d loads", loads.Load())
+	}
+}
+
+func TestProviderRegistry_CanceledCallerDoesNotPoison(t *testing.T) {
+	registry := newProviderRegistry()
+	key := providerKey("test", nil)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	load := func(ctx context.Context) ([]netip.Prefix, bool) {
+		if ctx.Err() != nil {
+			return nil, false
+		}
+
+		return []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")}, true
+	}
+
+	ips := registry.lookup(ctx, key, load)
+	if len(ips) != 1 {
+		t.Fatalf("expected load to run on a detached context, got %d ranges", len(ips))
+	}
+}
+
+func TestProviderRegistry_IdleEntryReused(t *testing.T) {
+	registry := newProviderRegistry()
+	key := providerKey("test", nil)
+
+	var loads atomic.Int32
+
+	load := func(_ context.Context) ([]netip.Prefix, bool) {
+		loads.Add(1)
+
+		return []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, true
+	}
+
+	registry.lookup(t.Context(), key, load)
+	registry.release(key)
+	registry.lookup(t.Context(), key, load)
+
+	if loads.Load() != 1 {
+		t.Errorf("expected idle entry to be reused, got %d loads", loads.Load())
+	}
+}
+
+func TestProviderRegistry_EmptyResultExpires(t *testing.T) {
+	registry := newProviderRegistry()
+	registry.negativeTTL = 0
+	key := providerKey("test", nil)
+
+	var loads atomic.Int32
+
+	load := func(_ context.Context) ([]netip.Prefix, bool) {
+		if loads.Add(1) == 1 {
+			return nil, true
+		}
+
+		return []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")}, true
+	}
+
+	if ips := registry.lookup(t.Context(), key, load); len(ips) != 0 {
+		t.Fatalf("expected empty first result, got %d", len(ips))
+	}
+
+	if ips := registry.lookup(t.Context(), key, load); len(ips) != 1 {
+		t.Fatalf("expected retry to load ranges, got %d", len(ips))
+	}
+}
+
+func TestProviderRegistry_DegradedResultExpires(t *testing.T) {
+	registry := newProviderRegistry()
+	registry.negativeTTL = 0
+	key := providerKey("test", nil)
+
+	var loads atomic.Int32
+
+	load := func(_ context.Context) ([]netip.Prefix, bool) {
+		if loads.Add(1) == 1 {
+			return []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")}, false
+		}
+
+		return []netip.Prefix{
+			netip.MustParsePrefix("203.0.113.0/24"),
+			netip.MustParsePrefix("198.51.100.0/24"),
+		}, true
+	}
+
+	if ips := registry.lookup(t.Context(), key, load); len(ips) != 1 {
+		t.Fatalf("expected the partial first result, got %d", len(ips))
+	}
+
+	if ips := registry.lookup(t.Context(), key, load); len(ips) != 2 {
+		t.Fatalf("expected the degraded result to be refetched, got %d", len(ips))
+	}
+
+	registry.lookup(t.Context(), key, load)
+
+	if loads.Load() != 2 {
+		t.Errorf("expected the complete result to be reused, got %d loads", loads.Load())
+	}
+}
+
+func TestProviderRegistry_CompleteResultExpires(t *testing.T) {
+	registry := newProviderRegistry()
+	registry.maxAge = 0
+	key := providerKey("test", nil)
+
+	var loads atomic.Int32
+
+	load := func(_ context.Context) ([]netip.Prefix, bool) {
+		loads.Add(1)
+
+		return []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, true
+	}
+
+	registry.lookup(t.Context(), key, load)
+	registry.lookup(t.Context(), key, load)
+
+	if loads.Load() != 2 {
+		t.Errorf("expected a result older than maxAge to be reloaded, got %d loads", loads.Load())
+	}
+}
+
+func TestNew_ReleasesProvidersWhenContextDone(t *testing.T) {
+	key := providerKey(localProviderName, nil)
+	before := defaultProviderRegistry.refs(key)
+
+	cfg := CreateConfig()
+	cfg.ThrustCloudFlare = false
+
+	ctx, cancel := context.WithCancel(t.Context())
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	_, err := New(ctx, next, cfg, "test")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if got := defaultProviderRegistry.refs(key); got != before+1 {
+		t.Fatalf("expected %d references after New, got %d", before+1, got)
+	}
+
+	cancel()
+
+	waitFor(t, func() bool { return defaultProviderRegistry.refs(key) == before })
+}
+
+func TestIPResolver_CloseReleasesProviders(t *testing.T) {
+	key := providerKey(localProviderName, nil)
+	before := defaultProviderRegistry.refs(key)
+
+	resolver := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.ThrustLocal = true
+	})
+
+	if got := defaultProviderRegistry.refs(key); got != before+1 {
+		t.Fatalf("expected %d references after New, got %d", before+1, got)
+	}
+
+	if err := resolver.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	waitFor(t, func() bool { return defaultProviderRegistry.refs(key) == before })
+
+	if err := resolver.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+
+	timeout := time.After(2 * time.Second)
+
+	for !cond() {
+		select {
+		case <-ticker.C:
+		case <-timeout:
+			t.Fatal("condition not met before timeout")
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package traefik_real_ip
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	ErrMissingSignature = errors.New("provider list is not signed")
+	ErrInvalidSignature = errors.New("invalid provider list signature")
+)
+
+// signatureSuffix is appended to a provider URL to locate its detached
+// signature.
+const signatureSuffix = ".sig"
+
+// parseSignatureKey reads an Ed25519 public key given either as the base64
+// encoding of the raw 32-byte key or as a PEM encoded PKIX public key.
+func parseSignatureKey(name, value string) (ed25519.PublicKey, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	invalid := fmt.Errorf("%w: %s.signaturePublicKey is not an Ed25519 public key",
+		ErrInvalidProviderConfig, name)
+
+	if block, _ := pem.Decode([]byte(value)); block != nil {
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, invalid
+		}
+
+		key, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return nil, invalid
+		}
+
+		return key, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, invalid
+	}
+
+	return ed25519.PublicKey(raw), nil
+}
+
+// decodeSignature accepts a raw 64-byte signature or its base64 encoding.
+func decodeSignature(body string) ([]byte, error) {
+	if len(body) == ed25519.SignatureSize {
+		return []byte(body), nil
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(body))
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidSignature)
+	}
+
+	return signature, nil
+}
+
+// verifyProviderSignature fetches the detached signature of url and checks
+// it against body. It is a no-op when no public key is configured.
+func (resolver *IPResolver) verifyProviderSignature(
+	ctx context.Context,
+	providerName, url, body string,
+) error {
+	key := resolver.providerSettingsFor(providerName).signatureKey
+	if key == nil {
+		return nil
+	}
+
+	encoded, err := resolver.fetchSignature(ctx, providerName, url+signatureSuffix)
+	if err != nil {
+		return err
+	}
+
+	signature, err := decodeSignature(encoded)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(key, []byte(body), signature) {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, url)
+	}
+
+	return nil
+}
+
+func (resolver *IPResolver) fetchSignature(
+	ctx context.Context,
+	providerName, sigURL string,
+) (string, error) {
+	path, isFile, err := fileURLPath(sigURL)
+	if err != nil {
+		return "", err
+	}
+
+	if isFile {
+		encoded, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrMissingSignature, err)
+		}
+
+		return string(encoded), nil
+	}
+
+	req, err := resolver.buildRequest(ctx, providerName, sigURL)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := resolver.doRequestWithRetry(ctx, req, providerName, sigURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrMissingSignature, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: %s", ErrMissingSignature, resp.Status)
+	}
+
+	return resolver.readResponseBody(ctx, resp, providerName, sigURL)
+}
@@ -0,0 +1,250 @@
+package traefik_real_ip
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+const signedList = "173.245.48.0/20\n103.21.244.0/22\n"
+
+func newSignedListServer(
+	t *testing.T,
+	signature func(body []byte) []byte,
+	body *atomic.Value,
+) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := body.Load().(string)
+
+		switch r.URL.Path {
+		case "/list":
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte(content))
+		case "/list" + signatureSuffix:
+			sig := signature([]byte(content))
+			if sig == nil {
+				http.NotFound(w, r)
+
+				return
+			}
+
+			_, _ = w.Write(sig)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func newSigningResolver(t *testing.T, public ed25519.PublicKey) *IPResolver {
+	t.Helper()
+
+	resolver := newTestResolver(t)
+	settings := resolver.providerSettingsFor("test")
+	settings.signatureKey = public
+	resolver.providerSettings["test"] = settings
+
+	return resolver
+}
+
+func TestParseSignatureKey(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		wantKey bool
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "base64", value: base64.StdEncoding.EncodeToString(public), wantKey: true},
+		{
+			name:    "PEM",
+			value:   string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			wantKey: true,
+		},
+		{name: "not base64", value: "not a key", wantErr: true},
+		{name: "wrong length", value: base64.StdEncoding.EncodeToString([]byte("short")), wantErr: true},
+		{
+			name:    "bad PEM",
+			value:   string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: []byte("x")})),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseSignatureKey("cloudFlare", tt.value)
+			if tt.wantErr != errors.Is(err, ErrInvalidProviderConfig) {
+				t.Fatalf("parseSignatureKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantKey && !public.Equal(key) {
+				t.Errorf("expected the configured key, got %x", key)
+			}
+		})
+	}
+}
+
+func TestGetProviderIPsFromURL_Signature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, otherPrivate, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		signature func(body []byte) []byte
+		wantErr   error
+	}{
+		{
+			name: "base64 signature",
+			signature: func(body []byte) []byte {
+				return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(private, body)) + "\n")
+			},
+		},
+		{
+			name: "raw signature",
+			signature: func(body []byte) []byte {
+				return ed25519.Sign(private, body)
+			},
+		},
+		{
+			name:      "unsigned",
+			signature: func([]byte) []byte { return nil },
+			wantErr:   ErrMissingSignature,
+		},
+		{
+			name: "signed by another key",
+			signature: func(body []byte) []byte {
+				return ed25519.Sign(otherPrivate, body)
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:      "malformed signature",
+			signature: func([]byte) []byte { return []byte("garbage") },
+			wantErr:   ErrInvalidSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body atomic.Value
+			body.Store(signedList)
+
+			server := newSignedListServer(t, tt.signature, &body)
+			resolver := newSigningResolver(t, public)
+
+			ips, err := resolver.getProviderIPsFromURL(t.Context(), "test", server.URL+"/list")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("expected %v, got %v", tt.wantErr, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(ips) != 2 {
+				t.Errorf("expected 2 ranges, got %v", ips)
+			}
+		})
+	}
+}
+
+func TestFetchProviderIPs_KeepsLastGoodListOnBadSignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		body     atomic.Value
+		tampered atomic.Bool
+	)
+
+	body.Store(signedList)
+
+	server := newSignedListServer(t, func(content []byte) []byte {
+		if tampered.Load() {
+			return ed25519.Sign(private, []byte(signedList))
+		}
+
+		return ed25519.Sign(private, content)
+	}, &body)
+
+	resolver := newSigningResolver(t, public)
+	provider := remoteIPProvider{name: "test", urls: []string{server.URL + "/list"}}
+
+	first, _ := resolver.fetchProviderIPs(t.Context(), provider)
+	if len(first) != 2 {
+		t.Fatalf("expected 2 ranges, got %v", first)
+	}
+
+	tampered.Store(true)
+	body.Store(signedList + "198.51.100.0/24\n")
+
+	second, _ := resolver.fetchProviderIPs(t.Context(), provider)
+	if len(second) != len(first) {
+		t.Errorf("expected the last good list to be kept, got %v", second)
+	}
+}
+
+func TestGetProviderIPs_SignedInstanceDoesNotReuseUnsignedList(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body atomic.Value
+	body.Store("1.0.0.0/8\n")
+
+	server := newSignedListServer(t, func([]byte) []byte { return nil }, &body)
+	provider := remoteIPProvider{name: "test", urls: []string{server.URL + "/list"}}
+
+	unsigned := newTestResolver(t)
+	signed := newSigningResolver(t, public)
+	signed.registry = unsigned.registry
+
+	ips := unsigned.getProviderIPs(t.Context(), provider)
+	if len(ips) != 1 {
+		t.Fatalf("expected the unsigned instance to load the list, got %v", ips)
+	}
+
+	ips = signed.getProviderIPs(t.Context(), provider)
+	if len(ips) != 0 {
+		t.Errorf("expected the signed instance to reject the unsigned list, got %v", ips)
+	}
+
+	cached, _ := signed.registry.cachedURL(signed.urlCacheKey("test", server.URL+"/list"))
+	if len(cached.ips) != 0 {
+		t.Errorf("expected no last good list for the signed instance, got %v", cached.ips)
+	}
+}
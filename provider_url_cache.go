@@ -0,0 +1,51 @@
+package traefik_real_ip
+
+import (
+	"net/http"
+	"net/netip"
+)
+
+// urlCacheEntry remembers the last good response of a provider URL: the
+// ranges parsed from it and its cache validators, if the provider sent any.
+type urlCacheEntry struct {
+	etag         string
+	lastModified string
+	ips          []netip.Prefix
+}
+
+func newURLCacheEntry(resp *http.Response, ips []netip.Prefix) urlCacheEntry {
+	return urlCacheEntry{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		ips:          ips,
+	}
+}
+
+// applyValidators turns req into a conditional request.
+func (entry urlCacheEntry) applyValidators(req *http.Request) {
+	if entry.etag != "" {
+		req.Header.Set("If-None-Match", entry.etag)
+	}
+
+	if entry.lastModified != "" {
+		req.Header.Set("If-Modified-Since", entry.lastModified)
+	}
+}
+
+// cachedURL returns the last good response stored under key, see
+// urlCacheKey.
+func (registry *providerRegistry) cachedURL(key string) (urlCacheEntry, bool) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	entry, ok := registry.urls[key]
+
+	return entry, ok
+}
+
+func (registry *providerRegistry) storeURL(key string, entry urlCacheEntry) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.urls[key] = entry
+}
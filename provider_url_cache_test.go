@@ -0,0 +1,167 @@
+package traefik_real_ip
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGetProviderIPsFromURL_ConditionalRequests(t *testing.T) {
+	const lastModified = "Wed, 21 Oct 2026 07:28:00 GMT"
+
+	tests := []struct {
+		name      string
+		header    string
+		value     string
+		condition string
+	}{
+		{name: "ETag", header: "ETag", value: `"v1"`, condition: "If-None-Match"},
+		{
+			name:      "Last-Modified",
+			header:    "Last-Modified",
+			value:     lastModified,
+			condition: "If-Modified-Since",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var full, notModified int
+
+			server := httptest.NewServer(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if r.Header.Get(tt.condition) == tt.value {
+						notModified++
+
+						w.WriteHeader(http.StatusNotModified)
+
+						return
+					}
+
+					full++
+
+					w.Header().Set(tt.header, tt.value)
+					w.WriteHeader(http.StatusOK)
+					_, _ = w.Write([]byte("173.245.48.0/20\n103.21.244.0/22\n"))
+				}),
+			)
+			defer server.Close()
+
+			resolver := newTestResolver(t)
+
+			first, err := resolver.getProviderIPsFromURL(t.Context(), "test", server.URL)
+			if err != nil {
+				t.Fatalf("first fetch: %v", err)
+			}
+
+			second, err := resolver.getProviderIPsFromURL(t.Context(), "test", server.URL)
+			if err != nil {
+				t.Fatalf("second fetch: %v", err)
+			}
+
+			if full != 1 || notModified != 1 {
+				t.Errorf("expected 1 full and 1 conditional response, got %d and %d",
+					full, notModified)
+			}
+
+			if len(first) != 2 || len(second) != len(first) {
+				t.Errorf("expected the cached ranges to be kept, got %v then %v", first, second)
+			}
+		})
+	}
+}
+
+func TestNew_ReloadSendsConditionalRequest(t *testing.T) {
+	var full, notModified atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+
+			w.WriteHeader(http.StatusNotModified)
+
+			return
+		}
+
+		full.Add(1)
+
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("173.245.48.0/20\n"))
+	}))
+	t.Cleanup(server.Close)
+
+	originalProvider := cloudflareProvider
+	originalRegistry := defaultProviderRegistry
+
+	cloudflareProvider = remoteIPProvider{name: originalProvider.name, urls: []string{server.URL}}
+	defaultProviderRegistry = newProviderRegistry()
+	defaultProviderRegistry.maxAge = 0
+
+	t.Cleanup(func() {
+		cloudflareProvider = originalProvider
+		defaultProviderRegistry = originalRegistry
+	})
+
+	configure := func(cfg *Config) {
+		cfg.ThrustCloudFlare = true
+		cfg.DenyUntrusted = true
+	}
+
+	for range 2 {
+		resolver := newConfiguredResolver(t, configure)
+		t.Cleanup(func() { _ = resolver.Close() })
+
+		recorder, _ := serveFrom(t, resolver, "173.245.48.1")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected the Cloudflare source to be trusted, got %d", recorder.Code)
+		}
+	}
+
+	if full.Load() != 1 || notModified.Load() != 1 {
+		t.Errorf("expected 1 full and 1 conditional response, got %d and %d",
+			full.Load(), notModified.Load())
+	}
+}
+
+func TestGetProviderIPsFromURL_NotModifiedWithoutCache(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer server.Close()
+
+	resolver := newTestResolver(t)
+
+	_, err := resolver.getProviderIPsFromURL(t.Context(), "test", server.URL)
+	if !errors.Is(err, ErrRemoteIPProviderHTTPStatus) {
+		t.Fatalf("expected ErrRemoteIPProviderHTTPStatus, got %v", err)
+	}
+}
+
+func TestGetProviderIPsFromURL_NoValidatorsSendsPlainRequest(t *testing.T) {
+	var conditional int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+			conditional++
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("10.0.0.0/8\n"))
+	}))
+	defer server.Close()
+
+	resolver := newTestResolver(t)
+
+	for range 2 {
+		_, err := resolver.getProviderIPsFromURL(t.Context(), "test", server.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if conditional != 0 {
+		t.Errorf("expected no conditional requests without validators, got %d", conditional)
+	}
+}
@@ -6,14 +6,16 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"net"
 	"net/http"
+	"net/netip"
 	"strings"
-	"sync"
 	"time"
 )
 
-var ErrRemoteIPProviderHTTPStatus = errors.New("failed to fetch remote IP provider ranges")
+var (
+	ErrRemoteIPProviderHTTPStatus = errors.New("failed to fetch remote IP provider ranges")
+	ErrRetryBudgetExceeded        = errors.New("retry budget exceeded")
+)
 
 const (
 	defaultRemoteProviderTimeout = 2 * time.Second
@@ -23,66 +25,197 @@ const (
 
 // remoteIPProvider describes a remote service exposing CIDR blocks.
 type remoteIPProvider struct {
-	once  *sync.Once
-	cache *[]*net.IPNet
-	name  string
-	urls  []string
+	name string
+	urls []string
 }
 
 func (resolver *IPResolver) getProviderIPs(
 	ctx context.Context,
 	provider remoteIPProvider,
-) []*net.IPNet {
-	provider.once.Do(func() {
-		results := make([]*net.IPNet, 0)
-
-		for _, url := range provider.urls {
-			ips, err := resolver.getProviderIPsFromURL(ctx, provider.name, url)
-			if err != nil {
-				// Log the error and continue with other URLs. Do not panic so tests
-				// and callers can handle missing remote data (e.g. via fallbacks).
-				resolver.logger.ErrorContext(
+) []netip.Prefix {
+	provider = resolver.providerWithURLs(provider)
+
+	// Local files are reloaded by each instance's file poller, so a shared
+	// entry would hand later instances a list that is never refreshed.
+	if hasFileURL(provider.urls) {
+		ips, _ := resolver.fetchProviderIPs(ctx, provider)
+
+		return ips
+	}
+
+	return resolver.lookupProvider(
+		ctx,
+		providerKey(provider.name, provider.urls)+"|"+resolver.providerVariant(provider.name),
+		func(ctx context.Context) ([]netip.Prefix, bool) {
+			return resolver.fetchProviderIPs(ctx, provider)
+		},
+	)
+}
+
+// providerWithURLs applies the configured mirror URLs, e.g. of an internal
+// artifact server, in place of the public ones.
+func (resolver *IPResolver) providerWithURLs(provider remoteIPProvider) remoteIPProvider {
+	urls := resolver.providerSettingsFor(provider.name).urls
+	if len(urls) > 0 {
+		provider.urls = urls
+	}
+
+	return provider
+}
+
+// fetchProviderIPs fetches every URL of provider. A URL that fails
+// contributes its last good ranges, if any, and makes the result degraded.
+func (resolver *IPResolver) fetchProviderIPs(
+	ctx context.Context,
+	provider remoteIPProvider,
+) ([]netip.Prefix, bool) {
+	results := make([]netip.Prefix, 0)
+	complete := true
+
+	// The retry budget covers every URL of the provider.
+	budget := resolver.providerSettingsFor(provider.name).retry.budget
+	if budget > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+	}
+
+	for _, url := range provider.urls {
+		ips, err := resolver.getProviderIPsFromURL(ctx, provider.name, url)
+		if err != nil {
+			// Log the error and continue with other URLs. Do not panic so tests
+			// and callers can handle missing remote data (e.g. via fallbacks).
+			resolver.logger.ErrorContext(
+				ctx,
+				"Error fetching provider IPs",
+				slog.String("provider", provider.name),
+				slog.String("url", url),
+				slog.Any("error", err),
+			)
+
+			complete = false
+
+			previous, ok := resolver.providerRegistry().cachedURL(
+				resolver.urlCacheKey(provider.name, url),
+			)
+			if ok {
+				resolver.logger.WarnContext(
 					ctx,
-					"Error fetching provider IPs",
+					"Using last good provider IPs",
 					slog.String("provider", provider.name),
 					slog.String("url", url),
-					slog.Any("error", err),
+					slog.Int("count", len(previous.ips)),
 				)
 
-				continue
+				results = append(results, previous.ips...)
 			}
 
-			results = append(results, ips...)
+			continue
 		}
 
-		*provider.cache = results
-	})
+		results = append(results, ips...)
+	}
 
-	return *provider.cache
+	return results, complete
 }
 
 func (resolver *IPResolver) getProviderIPsFromURL(
 	ctx context.Context,
 	providerName string,
 	url string,
-) ([]*net.IPNet, error) {
+) ([]netip.Prefix, error) {
+	path, isFile, err := fileURLPath(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if isFile {
+		return resolver.getProviderIPsFromFile(ctx, providerName, url, path)
+	}
+
 	req, err := resolver.buildRequest(ctx, providerName, url)
 	if err != nil {
 		return nil, err
 	}
 
+	registry := resolver.providerRegistry()
+	guards := resolver.providerSettingsFor(providerName).guards
+	cacheKey := resolver.urlCacheKey(providerName, url)
+
+	previous, cached := registry.cachedURL(cacheKey)
+	if cached {
+		previous.applyValidators(req)
+	}
+
 	resp, err := resolver.doRequestWithRetry(ctx, req, providerName, url)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		if !cached {
+			return nil, fmt.Errorf(
+				"%w: unexpected %s", ErrRemoteIPProviderHTTPStatus, resp.Status,
+			)
+		}
+
+		resolver.logger.DebugContext(
+			ctx,
+			"Provider IPs not modified",
+			slog.String("provider", providerName),
+			slog.String("url", url),
+			slog.Int("count", len(previous.ips)),
+		)
+
+		return previous.ips, nil
+	}
+
+	err = guards.checkContentType(resp)
+	if err != nil {
+		return nil, err
+	}
+
 	body, err := resolver.readResponseBody(ctx, resp, providerName, url)
 	if err != nil {
 		return nil, err
 	}
 
-	return resolver.parseCIDRs(ctx, body, providerName)
+	ips, err := resolver.acceptProviderList(ctx, providerName, url, body, previous.ips)
+	if err != nil {
+		return nil, err
+	}
+
+	registry.storeURL(cacheKey, newURLCacheEntry(resp, ips))
+
+	return ips, nil
+}
+
+// acceptProviderList verifies, parses and sanity checks a downloaded list.
+func (resolver *IPResolver) acceptProviderList(
+	ctx context.Context,
+	providerName, url, body string,
+	previous []netip.Prefix,
+) ([]netip.Prefix, error) {
+	err := resolver.verifyProviderSignature(ctx, providerName, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := resolver.providerSettingsFor(providerName)
+
+	ips, err := resolver.parseRanges(ctx, body, providerName, settings.format)
+	if err != nil {
+		return nil, err
+	}
+
+	err = settings.guards.checkRanges(ips, previous)
+	if err != nil {
+		return nil, err
+	}
+
+	return ips, nil
 }
 
 func (resolver *IPResolver) buildRequest(
@@ -103,6 +236,10 @@ func (resolver *IPResolver) buildRequest(
 		return nil, fmt.Errorf("error creating HTTP request: %w", err)
 	}
 
+	for key, values := range resolver.providerHTTPClient().headers {
+		req.Header[key] = values
+	}
+
 	return req, nil
 }
 
@@ -112,16 +249,50 @@ func (resolver *IPResolver) doRequestWithRetry(
 	providerName string,
 	url string,
 ) (*http.Response, error) {
-	client := &http.Client{Timeout: defaultRemoteProviderTimeout}
+	client := resolver.providerHTTPClient().client
+	policy := resolver.providerSettingsFor(providerName).retry
+
+	var deadline time.Time
+	if policy.budget > 0 {
+		deadline = time.Now().Add(policy.budget)
+	}
+
+	ctxDeadline, ok := ctx.Deadline()
+	if ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	}
 
 	var (
-		lastErr  error
-		response *http.Response
+		lastErr       error
+		response      *http.Response
+		retryAfter    time.Duration
+		hasRetryAfter bool
 	)
 
-	for attempt := 0; attempt <= maxRetries; attempt++ {
+	for attempt := 0; attempt <= policy.maxRetries; attempt++ {
 		if attempt > 0 {
-			delay := initialRetryDelay
+			delay := policy.backoff(attempt)
+			if hasRetryAfter {
+				delay = min(retryAfter, policy.maxDelay)
+			}
+
+			if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+				resolver.logger.ErrorContext(
+					ctx,
+					"Retry budget exhausted",
+					slog.String("provider", providerName),
+					slog.String("url", url),
+					slog.Int("attempts", attempt),
+					slog.Duration("budget", policy.budget),
+					slog.Any("error", lastErr),
+				)
+
+				return nil, fmt.Errorf(
+					"%w: %s after %d attempts: %w",
+					ErrRetryBudgetExceeded, providerName, attempt, lastErr,
+				)
+			}
+
 			resolver.logger.InfoContext(
 				ctx,
 				"Retrying request",
@@ -138,6 +309,8 @@ func (resolver *IPResolver) doRequestWithRetry(
 			}
 		}
 
+		hasRetryAfter = false
+
 		response, lastErr = client.Do(req)
 		if lastErr != nil {
 			resolver.logger.WarnContext(
@@ -152,10 +325,11 @@ func (resolver *IPResolver) doRequestWithRetry(
 			continue
 		}
 
-		if response.StatusCode != http.StatusOK {
+		// 304 Not Modified answers a conditional request; see getProviderIPsFromURL.
+		if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNotModified {
 			lastErr = fmt.Errorf("%w: %s", ErrRemoteIPProviderHTTPStatus, response.Status)
 
-			if response.StatusCode >= 400 && response.StatusCode < 500 {
+			if !isRetryableStatus(response.StatusCode) {
 				resolver.logger.ErrorContext(
 					ctx,
 					"Client error, will not retry",
@@ -164,38 +338,30 @@ func (resolver *IPResolver) doRequestWithRetry(
 					slog.Int("statusCode", response.StatusCode),
 				)
 
-				closeErr := response.Body.Close()
-				if closeErr != nil {
-					resolver.logger.WarnContext(
-						ctx,
-						"Error closing response body",
-						slog.String("provider", providerName),
-						slog.String("url", url),
-						slog.Any("error", closeErr),
-					)
-				}
+				resolver.closeResponseBody(ctx, response, providerName, url)
 
 				return nil, lastErr
 			}
 
+			// Retry-After is only meaningful for rate limiting and maintenance.
+			if response.StatusCode == http.StatusTooManyRequests ||
+				response.StatusCode == http.StatusServiceUnavailable {
+				retryAfter, hasRetryAfter = parseRetryAfter(
+					response.Header.Get("Retry-After"),
+					time.Now(),
+				)
+			}
+
 			resolver.logger.WarnContext(
 				ctx,
 				"Non-OK status, retrying",
 				slog.String("provider", providerName),
 				slog.String("url", url),
 				slog.Int("statusCode", response.StatusCode),
+				slog.Duration("retryAfter", retryAfter),
 			)
 
-			closeErr := response.Body.Close()
-			if closeErr != nil {
-				resolver.logger.WarnContext(
-					ctx,
-					"Error closing response body",
-					slog.String("provider", providerName),
-					slog.String("url", url),
-					slog.Any("error", closeErr),
-				)
-			}
+			resolver.closeResponseBody(ctx, response, providerName, url)
 
 			continue
 		}
@@ -209,22 +375,46 @@ func (resolver *IPResolver) doRequestWithRetry(
 		"Failed to fetch provider IPs after retries",
 		slog.String("provider", providerName),
 		slog.String("url", url),
-		slog.Int("totalAttempts", maxRetries+1),
+		slog.Int("totalAttempts", policy.maxRetries+1),
 		slog.Any("error", lastErr),
 	)
 
 	return nil, fmt.Errorf(
 		"error fetching %s IPs after %d retries: %w",
-		providerName, maxRetries+1, lastErr,
+		providerName, policy.maxRetries+1, lastErr,
 	)
 }
 
+func (resolver *IPResolver) closeResponseBody(
+	ctx context.Context,
+	response *http.Response,
+	providerName, url string,
+) {
+	closeErr := response.Body.Close()
+	if closeErr != nil {
+		resolver.logger.WarnContext(
+			ctx,
+			"Error closing response body",
+			slog.String("provider", providerName),
+			slog.String("url", url),
+			slog.Any("error", closeErr),
+		)
+	}
+}
+
 func (resolver *IPResolver) readResponseBody(
 	ctx context.Context,
 	resp *http.Response,
 	providerName, url string,
 ) (string, error) {
-	bytes, err := io.ReadAll(resp.Body)
+	maxBodySize := resolver.providerSettingsFor(providerName).guards.maxBodySize
+
+	reader := io.Reader(resp.Body)
+	if maxBodySize > 0 {
+		reader = io.LimitReader(resp.Body, maxBodySize+1)
+	}
+
+	bytes, err := io.ReadAll(reader)
 	if err != nil {
 		resolver.logger.ErrorContext(
 			ctx,
@@ -237,6 +427,10 @@ func (resolver *IPResolver) readResponseBody(
 		return "", fmt.Errorf("error reading response body: %w", err)
 	}
 
+	if maxBodySize > 0 && int64(len(bytes)) > maxBodySize {
+		return "", fmt.Errorf("%w: more than %d bytes", ErrResponseTooLarge, maxBodySize)
+	}
+
 	return string(bytes), nil
 }
 
@@ -244,8 +438,8 @@ func (resolver *IPResolver) parseCIDRs(
 	ctx context.Context,
 	body string,
 	providerName string,
-) ([]*net.IPNet, error) {
-	ips := make([]*net.IPNet, 0)
+) ([]netip.Prefix, error) {
+	ips := make([]netip.Prefix, 0)
 
 	//nolint:modernize // yaegi does not support strings.SplitSeq
 	lines := strings.Split(body, "\n")
@@ -260,7 +454,7 @@ func (resolver *IPResolver) parseCIDRs(
 			continue
 		}
 
-		_, block, err := net.ParseCIDR(cidr)
+		block, err := parsePrefix(cidr)
 		if err != nil {
 			resolver.logger.ErrorContext(
 				ctx,
@@ -4,23 +4,41 @@ import (
 	"context"
 	"errors"
 	"io"
-	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func newTestResolver(t *testing.T) *IPResolver {
 	t.Helper()
 
-	return &IPResolver{logger: NewPluginLogger(t.Context(), "test", LogLevelDebug)}
+	return &IPResolver{
+		logger:           NewPluginLogger(t.Context(), "test", LogLevelDebug),
+		providerSettings: fastRetrySettings(),
+		registry:         newProviderRegistry(),
+	}
+}
+
+// fastRetrySettings keeps retry delays short so failing fetches finish quickly.
+func fastRetrySettings() map[string]providerSettings {
+	settings := defaultProviderSettings()
+	settings.retry.initialDelay = time.Millisecond
+	settings.retry.maxDelay = 5 * time.Millisecond
+
+	return map[string]providerSettings{
+		cloudflareProvider.name: settings,
+		edgeOneProvider.name:    settings,
+		"test":                  settings,
+	}
 }
 
 func runRemoteProviderResponseTests(
 	t *testing.T,
-	fetch func(context.Context, string) ([]*net.IPNet, error),
+	fetch func(context.Context, string) ([]netip.Prefix, error),
 ) {
 	t.Helper()
 
@@ -128,9 +146,9 @@ invalid-cidr
 				t.Errorf("Expected %d IPs, got %d", tc.expectedIPsLen, len(ips))
 			}
 
-			for i, ipNet := range ips {
-				if ipNet == nil {
-					t.Errorf("IP at index %d is nil", i)
+			for i, prefix := range ips {
+				if !prefix.IsValid() {
+					t.Errorf("IP at index %d is invalid", i)
 				}
 			}
 		})
@@ -139,7 +157,7 @@ invalid-cidr
 
 func runCIDRParsingTests(
 	t *testing.T,
-	fetch func(context.Context, string) ([]*net.IPNet, error),
+	fetch func(context.Context, string) ([]netip.Prefix, error),
 ) {
 	t.Helper()
 
@@ -175,14 +193,14 @@ func runCIDRParsingTests(
 		t.Errorf("Expected %d parsed IPs, got %d", len(validCIDRs), len(ips))
 	}
 
-	for i, ipNet := range ips {
-		if ipNet == nil {
-			t.Errorf("IP network at index %d is nil", i)
+	for i, prefix := range ips {
+		if !prefix.IsValid() {
+			t.Errorf("IP network at index %d is invalid", i)
 
 			continue
 		}
 
-		if ipNet.IP.String() == "" {
+		if prefix.Addr().String() == "" {
 			t.Errorf("Empty network address for IP at index %d", i)
 		}
 	}
@@ -190,7 +208,7 @@ func runCIDRParsingTests(
 
 func runHTTPErrorTests(
 	t *testing.T,
-	fetch func(context.Context, string) ([]*net.IPNet, error),
+	fetch func(context.Context, string) ([]netip.Prefix, error),
 ) {
 	t.Helper()
 
@@ -306,10 +324,8 @@ func TestGetProviderIPs_MultiURL_FirstFails(t *testing.T) {
 	resolver := newTestResolver(t)
 
 	provider := remoteIPProvider{
-		once:  &sync.Once{},
-		cache: new(make([]*net.IPNet, 0)),
-		name:  "test",
-		urls:  []string{failServer.URL, successServer.URL},
+		name: "test",
+		urls: []string{failServer.URL, successServer.URL},
 	}
 
 	ips := resolver.getProviderIPs(t.Context(), provider)
@@ -317,3 +333,160 @@ func TestGetProviderIPs_MultiURL_FirstFails(t *testing.T) {
 		t.Errorf("expected 2 IPs from second URL, got %d", len(ips))
 	}
 }
+
+func TestDoRequestWithRetry_TooManyRequestsHonoursRetryAfter(t *testing.T) {
+	var attempts int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("10.0.0.0/8\n"))
+	}))
+	defer server.Close()
+
+	resolver := newTestResolver(t)
+
+	ips, err := resolver.getProviderIPsFromURL(t.Context(), "test", server.URL)
+	if err != nil {
+		t.Fatalf("expected 429 to be retried, got %v", err)
+	}
+
+	if attempts != 2 || len(ips) != 1 {
+		t.Errorf("expected 2 attempts and 1 range, got %d attempts and %d ranges", attempts, len(ips))
+	}
+}
+
+func TestDoRequestWithRetry_RetryAfterBeyondBudget(t *testing.T) {
+	var attempts int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	resolver := newTestResolver(t)
+	settings := resolver.providerSettings["test"]
+	settings.retry.maxDelay = 2 * time.Hour
+	resolver.providerSettings["test"] = settings
+
+	_, err := resolver.getProviderIPsFromURL(t.Context(), "test", server.URL)
+	if !errors.Is(err, ErrRetryBudgetExceeded) {
+		t.Fatalf("expected ErrRetryBudgetExceeded, got %v", err)
+	}
+
+	if attempts != 1 {
+		t.Errorf("expected a single attempt, got %d", attempts)
+	}
+}
+
+func TestDoRequestWithRetry_RetryAfterCappedAtMaxDelay(t *testing.T) {
+	var attempts atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	resolver := newTestResolver(t)
+	settings := resolver.providerSettings["test"]
+	settings.retry.budget = 0
+	settings.retry.maxRetries = 2
+	resolver.providerSettings["test"] = settings
+
+	start := time.Now()
+
+	_, err := resolver.getProviderIPsFromURL(t.Context(), "test", server.URL)
+	if err == nil {
+		t.Fatal("expected error after exhausting retries")
+	}
+
+	if attempts.Load() != 3 || time.Since(start) > time.Second {
+		t.Errorf("expected 3 quick attempts, got %d in %s", attempts.Load(), time.Since(start))
+	}
+}
+
+func TestDoRequestWithRetry_RetryAfterIgnoredForOtherStatuses(t *testing.T) {
+	var attempts atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		_, _ = w.Write([]byte("10.0.0.0/8\n"))
+	}))
+	defer server.Close()
+
+	resolver := newTestResolver(t)
+	settings := resolver.providerSettings["test"]
+	settings.retry.maxDelay = 2 * time.Hour
+	resolver.providerSettings["test"] = settings
+
+	ips, err := resolver.getProviderIPsFromURL(t.Context(), "test", server.URL)
+	if err != nil || len(ips) != 1 {
+		t.Fatalf("expected the backoff delay to be used, got %v, %v", ips, err)
+	}
+}
+
+func TestDoRequestWithRetry_ClientErrorNotRetried(t *testing.T) {
+	var attempts int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	resolver := newTestResolver(t)
+
+	_, err := resolver.getProviderIPsFromURL(t.Context(), "test", server.URL)
+	if !errors.Is(err, ErrRemoteIPProviderHTTPStatus) {
+		t.Fatalf("expected ErrRemoteIPProviderHTTPStatus, got %v", err)
+	}
+
+	if attempts != 1 {
+		t.Errorf("expected a single attempt, got %d", attempts)
+	}
+}
+
+func TestDoRequestWithRetry_MaxRetries(t *testing.T) {
+	var attempts int
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	resolver := newTestResolver(t)
+	settings := resolver.providerSettings["test"]
+	settings.retry.maxRetries = 2
+	resolver.providerSettings["test"] = settings
+
+	_, err := resolver.getProviderIPsFromURL(t.Context(), "test", server.URL)
+	if err == nil {
+		t.Fatal("expected error after exhausting retries")
+	}
+
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+}
@@ -0,0 +1,106 @@
+package traefik_real_ip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var ErrInvalidNotReadyPolicy = errors.New("invalid not-ready policy")
+
+func parseNotReadyPolicy(policy string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(policy)) {
+	case "", NotReadyPolicyStatic:
+		return NotReadyPolicyStatic, nil
+	case NotReadyPolicyReject:
+		return NotReadyPolicyReject, nil
+	case NotReadyPolicyPending:
+		return NotReadyPolicyPending, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrInvalidNotReadyPolicy, policy)
+	}
+}
+
+// backgroundLoadRetry controls how a failed background load is retried.
+// The first retry waits at least as long as the registry reuses an empty
+// result, otherwise it would just get the failed result back.
+var backgroundLoadRetry = retryPolicy{
+	initialDelay: providerNegativeTTL,
+	maxDelay:     10 * time.Minute,
+}
+
+// loadRemoteProvidersInBackground resolves the discovered sources and
+// fetches the remote providers without blocking New. Until the load
+// completes, requests are handled according to the configured not-ready
+// policy. If a required provider fails, the
+// resolver falls back to the static ranges and keeps retrying with backoff
+// until the load succeeds or the instance is closed.
+func (resolver *IPResolver) loadRemoteProvidersInBackground(ctx context.Context) {
+	resolver.providersPending.Store(true)
+
+	loadCtx := context.WithoutCancel(ctx)
+
+	go func() {
+		defer func() {
+			err := getPanicError(recover())
+			if err != nil {
+				resolver.logger.ErrorContext(loadCtx, "Panic while loading providers", ErrorAttr(err))
+			}
+		}()
+
+		resolver.watchDiscoveredSources(ctx)
+
+		delay := backgroundLoadRetry.initialDelay
+
+		for {
+			err := resolver.loadRemoteProviders(loadCtx)
+			if err == nil {
+				break
+			}
+
+			// New can no longer report the error, so stop holding requests
+			// back and serve the static ranges until a retry succeeds.
+			resolver.providersPending.Store(false)
+
+			resolver.logger.ErrorContext(
+				loadCtx,
+				"Error loading trusted IP providers, using the static ranges until a retry succeeds",
+				slog.Any("error", err),
+				slog.Duration("retryIn", delay),
+			)
+
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+
+			delay = min(delay*2, backgroundLoadRetry.maxDelay)
+		}
+
+		resolver.providersPending.Store(false)
+
+		resolver.trustMu.RLock()
+		count := len(resolver.trustedIPNets)
+		resolver.trustMu.RUnlock()
+
+		resolver.logger.InfoContext(
+			loadCtx,
+			"Trusted IP providers loaded",
+			slog.Int("count", count),
+		)
+	}()
+}
+
+// notReadyPolicy returns the policy to apply to the current request, or an
+// empty string once every provider has been loaded.
+func (resolver *IPResolver) notReadyPolicy() string {
+	if !resolver.providersPending.Load() {
+		return ""
+	}
+
+	return resolver.notReady
+}
@@ -0,0 +1,247 @@
+package traefik_real_ip
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseNotReadyPolicy(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{input: "", expected: NotReadyPolicyStatic},
+		{input: "static", expected: NotReadyPolicyStatic},
+		{input: "Reject", expected: NotReadyPolicyReject},
+		{input: " pending ", expected: NotReadyPolicyPending},
+		{input: "wait", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			policy, err := parseNotReadyPolicy(tt.input)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidNotReadyPolicy) {
+					t.Errorf("expected ErrInvalidNotReadyPolicy, got %v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if policy != tt.expected {
+				t.Errorf("parseNotReadyPolicy(%q) = %q, want %q", tt.input, policy, tt.expected)
+			}
+		})
+	}
+}
+
+// newBlockedCloudflareResolver starts a resolver in non-blocking mode whose
+// Cloudflare fetch blocks until the returned function is called.
+func newBlockedCloudflareResolver(
+	t *testing.T,
+	configure func(*Config),
+) (*IPResolver, func()) {
+	t.Helper()
+
+	unblock := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("173.245.48.0/20\n"))
+	}))
+	t.Cleanup(server.Close)
+
+	originalProvider := cloudflareProvider
+	cloudflareProvider = remoteIPProvider{
+		name: originalProvider.name,
+		urls: []string{server.URL},
+	}
+
+	t.Cleanup(func() {
+		cloudflareProvider = originalProvider
+	})
+
+	resolver := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.ThrustLocal = true
+		cfg.ThrustCloudFlare = true
+		cfg.NonBlockingStartup = true
+		configure(cfg)
+	})
+
+	var once bool
+
+	release := func() {
+		if !once {
+			once = true
+
+			close(unblock)
+		}
+	}
+
+	// Let the background load finish before the provider is restored.
+	t.Cleanup(func() {
+		release()
+		waitFor(t, func() bool { return resolver.notReadyPolicy() == "" })
+	})
+
+	return resolver, release
+}
+
+func serveFrom(
+	t *testing.T,
+	handler http.Handler,
+	remote string,
+) (*httptest.ResponseRecorder, *http.Request) {
+	t.Helper()
+
+	req := httptest.NewRequestWithContext(
+		t.Context(),
+		http.MethodGet,
+		"http://localhost",
+		http.NoBody,
+	)
+	req.RemoteAddr = remote + ":1234"
+	req.Header.Set(CfConnectingIP, "1.2.3.4")
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	return recorder, req
+}
+
+func TestNonBlockingStartup_StaticPolicy(t *testing.T) {
+	resolver, unblock := newBlockedCloudflareResolver(t, func(cfg *Config) {
+		cfg.DenyUntrusted = true
+	})
+
+	// Static ranges are usable immediately.
+	recorder, req := serveFrom(t, resolver, "10.0.0.1")
+	if recorder.Code != http.StatusOK || req.Header.Get(XRealIP) != "1.2.3.4" {
+		t.Fatalf(
+			"expected local source to be trusted, got %d %q",
+			recorder.Code,
+			req.Header.Get(XRealIP),
+		)
+	}
+
+	recorder, _ = serveFrom(t, resolver, "173.245.48.1")
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected Cloudflare source to be denied before load, got %d", recorder.Code)
+	}
+
+	unblock()
+	waitFor(t, func() bool { return resolver.notReadyPolicy() == "" })
+
+	recorder, req = serveFrom(t, resolver, "173.245.48.1")
+	if recorder.Code != http.StatusOK || req.Header.Get(XIsTrusted) != "yes" {
+		t.Fatalf("expected Cloudflare source to be trusted after load, got %d %q",
+			recorder.Code, req.Header.Get(XIsTrusted))
+	}
+}
+
+func TestNonBlockingStartup_RejectPolicy(t *testing.T) {
+	resolver, unblock := newBlockedCloudflareResolver(t, func(cfg *Config) {
+		cfg.NotReadyPolicy = NotReadyPolicyReject
+	})
+
+	recorder, _ := serveFrom(t, resolver, "10.0.0.1")
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 while loading, got %d", recorder.Code)
+	}
+
+	unblock()
+	waitFor(t, func() bool { return resolver.notReadyPolicy() == "" })
+
+	recorder, _ = serveFrom(t, resolver, "10.0.0.1")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected 200 after load, got %d", recorder.Code)
+	}
+}
+
+func TestNonBlockingStartup_PendingPolicy(t *testing.T) {
+	resolver, _ := newBlockedCloudflareResolver(t, func(cfg *Config) {
+		cfg.NotReadyPolicy = NotReadyPolicyPending
+		cfg.DenyUntrusted = true
+	})
+
+	recorder, req := serveFrom(t, resolver, "173.245.48.1")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected pending request to be served, got %d", recorder.Code)
+	}
+
+	if req.Header.Get(XIsTrusted) != TrustedPending {
+		t.Errorf("expected %s=%s, got %q", XIsTrusted, TrustedPending, req.Header.Get(XIsTrusted))
+	}
+}
+
+func TestNonBlockingStartup_FailedLoadFallsBackAndRetries(t *testing.T) {
+	var healthy atomic.Bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		_, _ = w.Write([]byte("173.245.48.0/20\n"))
+	}))
+	t.Cleanup(server.Close)
+
+	originalProvider := cloudflareProvider
+	originalRegistry := defaultProviderRegistry
+	originalRetry := backgroundLoadRetry
+
+	cloudflareProvider = remoteIPProvider{name: originalProvider.name, urls: []string{server.URL}}
+	defaultProviderRegistry = newProviderRegistry()
+	defaultProviderRegistry.negativeTTL = 0
+	backgroundLoadRetry = retryPolicy{initialDelay: time.Millisecond, maxDelay: 5 * time.Millisecond}
+
+	t.Cleanup(func() {
+		cloudflareProvider = originalProvider
+		defaultProviderRegistry = originalRegistry
+		backgroundLoadRetry = originalRetry
+	})
+
+	resolver := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.ThrustLocal = true
+		cfg.ThrustCloudFlare = true
+		cfg.NonBlockingStartup = true
+		cfg.NotReadyPolicy = NotReadyPolicyReject
+		cfg.DenyUntrusted = true
+		cfg.CloudFlare.Required = true
+		cfg.CloudFlare.RetryInitialDelay = "1ms"
+		cfg.CloudFlare.RetryMaxDelay = "1ms"
+	})
+	t.Cleanup(func() { _ = resolver.Close() })
+
+	// The failed load must not leave the instance rejecting every request.
+	waitFor(t, func() bool { return resolver.notReadyPolicy() == "" })
+
+	recorder, _ := serveFrom(t, resolver, "10.0.0.1")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected the static ranges to be served after a failed load, got %d", recorder.Code)
+	}
+
+	recorder, _ = serveFrom(t, resolver, "173.245.48.1")
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("expected Cloudflare source to be denied before a retry succeeds, got %d", recorder.Code)
+	}
+
+	healthy.Store(true)
+
+	waitFor(t, func() bool {
+		recorder, _ := serveFrom(t, resolver, "173.245.48.1")
+
+		return recorder.Code == http.StatusOK
+	})
+}
@@ -0,0 +1,179 @@
+package traefik_real_ip
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// temporarySourceName labels the ranges of time-limited trustedIPs entries.
+const temporarySourceName = "temporary"
+
+// timedEntry is a trustedIPs entry only trusted between notBefore and
+// notAfter. A zero bound is open.
+type timedEntry struct {
+	notBefore time.Time
+	notAfter  time.Time
+	entry     string
+	prefixes  []netip.Prefix
+	active    bool
+}
+
+// parseTimedAttributes reads the notBefore=<RFC 3339> and notAfter=<RFC 3339>
+// attributes following the address of a trustedIPs entry.
+func parseTimedAttributes(entry string, attributes []string) (*timedEntry, error) {
+	timed := &timedEntry{entry: entry}
+
+	for _, attribute := range attributes {
+		key, value, ok := strings.Cut(attribute, "=")
+		if !ok {
+			return nil, fmt.Errorf(
+				"%w: %s: invalid attribute %q", ErrInvalidTrustedIPRange, entry, attribute,
+			)
+		}
+
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s: %w", ErrInvalidTrustedIPRange, entry, key, err)
+		}
+
+		switch strings.ToLower(key) {
+		case "notbefore":
+			timed.notBefore = parsed
+		case "notafter":
+			timed.notAfter = parsed
+		default:
+			return nil, fmt.Errorf(
+				"%w: %s: unknown attribute %q", ErrInvalidTrustedIPRange, entry, key,
+			)
+		}
+	}
+
+	if !timed.notAfter.IsZero() && !timed.notAfter.After(timed.notBefore) {
+		return nil, fmt.Errorf(
+			"%w: %s: notAfter must be after notBefore", ErrInvalidTrustedIPRange, entry,
+		)
+	}
+
+	return timed, nil
+}
+
+func (entry *timedEntry) expired(now time.Time) bool {
+	return !entry.notAfter.IsZero() && !now.Before(entry.notAfter)
+}
+
+func (entry *timedEntry) activeAt(now time.Time) bool {
+	return !now.Before(entry.notBefore) && !entry.expired(now)
+}
+
+// refreshTimedEntries installs the time-limited entries active now, logs
+// entries that activated or expired since the last refresh and drops expired
+// entries. It does nothing before the next scheduled transition.
+func (resolver *IPResolver) refreshTimedEntries(ctx context.Context) {
+	resolver.timedMu.Lock()
+	defer resolver.timedMu.Unlock()
+
+	now := time.Now()
+
+	deadline := resolver.timedDeadline.Load()
+	if deadline == 0 || now.UnixNano() < deadline {
+		return
+	}
+
+	active := make([]netip.Prefix, 0)
+	remaining := resolver.timedEntries[:0]
+
+	var next time.Time
+
+	for _, entry := range resolver.timedEntries {
+		if entry.expired(now) {
+			resolver.logger.InfoContext(
+				ctx,
+				"Temporary trusted entry expired",
+				slog.String("entry", entry.entry),
+				slog.Time("notAfter", entry.notAfter),
+			)
+
+			continue
+		}
+
+		remaining = append(remaining, entry)
+
+		transition := entry.notAfter
+
+		if entry.activeAt(now) {
+			active = append(active, entry.prefixes...)
+
+			if !entry.active {
+				entry.active = true
+
+				resolver.logger.InfoContext(
+					ctx,
+					"Temporary trusted entry activated",
+					slog.String("entry", entry.entry),
+				)
+			}
+		} else {
+			transition = entry.notBefore
+		}
+
+		if !transition.IsZero() && (next.IsZero() || transition.Before(next)) {
+			next = transition
+		}
+	}
+
+	resolver.timedEntries = remaining
+	resolver.setTrustedSources(map[string][]netip.Prefix{temporarySourceName: active})
+
+	if next.IsZero() {
+		resolver.timedDeadline.Store(0)
+	} else {
+		resolver.timedDeadline.Store(next.UnixNano())
+	}
+}
+
+// refreshTimedEntriesIfDue applies a due transition before a trust
+// evaluation, so that entries are honoured at request time.
+func (resolver *IPResolver) refreshTimedEntriesIfDue(ctx context.Context) {
+	deadline := resolver.timedDeadline.Load()
+	if deadline != 0 && time.Now().UnixNano() >= deadline {
+		resolver.refreshTimedEntries(ctx)
+	}
+}
+
+// scheduleTimedEntries installs the time-limited entries and refreshes them
+// at every transition until none is left or ctx is done.
+func (resolver *IPResolver) scheduleTimedEntries(ctx context.Context, entries []*timedEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	resolver.timedEntries = entries
+
+	// Any past deadline makes the first refresh run.
+	resolver.timedDeadline.Store(1)
+	resolver.refreshTimedEntries(ctx)
+
+	go func() {
+		for {
+			deadline := resolver.timedDeadline.Load()
+			if deadline == 0 {
+				return
+			}
+
+			timer := time.NewTimer(time.Until(time.Unix(0, deadline)))
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+
+				return
+			case <-timer.C:
+				resolver.refreshTimedEntries(ctx)
+			}
+		}
+	}()
+}
@@ -0,0 +1,124 @@
+package traefik_real_ip
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestParseTrustedIPs_TimedEntries(t *testing.T) {
+	parsed, err := parseTrustedIPs([]string{
+		"198.51.100.0/24 notBefore=2026-10-01T00:00:00Z notAfter=2026-10-02T00:00:00Z",
+		"192.0.2.1 NOTAFTER=2026-10-02T00:00:00+02:00",
+	}, nil)
+	if err != nil {
+		t.Fatalf("parseTrustedIPs: %v", err)
+	}
+
+	if len(parsed.trusted) != 0 || len(parsed.timed) != 2 {
+		t.Fatalf("expected 2 timed entries, got %+v", parsed)
+	}
+
+	first := parsed.timed[0]
+	if first.prefixes[0] != netip.MustParsePrefix("198.51.100.0/24") ||
+		!first.notBefore.Equal(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)) ||
+		!first.notAfter.Equal(time.Date(2026, 10, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected entry %+v", first)
+	}
+
+	if !parsed.timed[1].notBefore.IsZero() {
+		t.Errorf("expected an open start, got %v", parsed.timed[1].notBefore)
+	}
+}
+
+func TestParseTrustedIPs_TimedEntryErrors(t *testing.T) {
+	entries := []string{
+		"198.51.100.0/24 notAfter",
+		"198.51.100.0/24 notAfter=tomorrow",
+		"198.51.100.0/24 validUntil=2026-10-02T00:00:00Z",
+		"198.51.100.0/24 notBefore=2026-10-02T00:00:00Z notAfter=2026-10-01T00:00:00Z",
+		"!198.51.100.0/24 notAfter=2026-10-02T00:00:00Z",
+	}
+
+	for _, entry := range entries {
+		_, err := parseTrustedIPs([]string{entry}, nil)
+		if !errors.Is(err, ErrInvalidTrustedIPRange) {
+			t.Errorf("%q: expected ErrInvalidTrustedIPRange, got %v", entry, err)
+		}
+	}
+}
+
+func TestNew_TimedEntries(t *testing.T) {
+	now := time.Now()
+	soon := now.Add(100 * time.Millisecond).Format(time.RFC3339Nano)
+
+	resolver := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.TrustedIPs = []string{
+			"10.0.0.0/24",
+			"192.0.2.0/24 notAfter=" + now.Add(-time.Hour).Format(time.RFC3339),
+			"198.51.100.0/24 notAfter=" + soon,
+			"203.0.113.0/24 notBefore=" + soon,
+		}
+	})
+
+	ctx := t.Context()
+	expired := netip.MustParseAddr("192.0.2.1")
+	ending := netip.MustParseAddr("198.51.100.1")
+	starting := netip.MustParseAddr("203.0.113.1")
+
+	trusted, provider := resolver.matchTrustedIP(ctx, ending)
+	if !trusted || provider != temporarySourceName {
+		t.Errorf("expected the active entry to be trusted, got %v %q", trusted, provider)
+	}
+
+	if resolver.isTrustedIP(ctx, expired) || resolver.isTrustedIP(ctx, starting) {
+		t.Error("expected expired and future entries to be untrusted")
+	}
+
+	// The timer applies the transition without any request.
+	waitFor(t, func() bool {
+		resolver.timedMu.Lock()
+		defer resolver.timedMu.Unlock()
+
+		return len(resolver.timedEntries) == 1
+	})
+
+	if resolver.isTrustedIP(ctx, ending) || !resolver.isTrustedIP(ctx, starting) {
+		t.Error("expected the entries to have switched")
+	}
+
+	if !resolver.isTrustedIP(ctx, netip.MustParseAddr("10.0.0.1")) {
+		t.Error("expected permanent entries to stay trusted")
+	}
+
+	if resolver.timedDeadline.Load() != 0 {
+		t.Error("expected no further transition")
+	}
+}
+
+func TestMatchTrustedIP_TimedEntryExpiresAtRequestTime(t *testing.T) {
+	parsed, err := parseTrustedIPs([]string{
+		"198.51.100.0/24 notAfter=" + time.Now().Add(50*time.Millisecond).Format(time.RFC3339Nano),
+	}, nil)
+	if err != nil {
+		t.Fatalf("parseTrustedIPs: %v", err)
+	}
+
+	resolver := newTestResolver(t)
+	resolver.trustCache = newTrustCache(16)
+
+	// Without a running timer, only the request-time check can expire it.
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	resolver.scheduleTimedEntries(ctx, parsed.timed)
+
+	addr := netip.MustParseAddr("198.51.100.1")
+	if !resolver.isTrustedIP(t.Context(), addr) {
+		t.Fatal("expected the entry to be trusted before it expires")
+	}
+
+	waitFor(t, func() bool { return !resolver.isTrustedIP(t.Context(), addr) })
+}
@@ -5,54 +5,130 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"net"
 	"net/http"
+	"net/netip"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 )
 
 // Static errors.
 var (
-	ErrGettingLocalIPs       = errors.New("error getting local IPs")
-	ErrGettingCloudflareIPs  = errors.New("error getting Cloudflare IPs")
-	ErrGettingEdgeOneIPs     = errors.New("error getting EdgeOne IPs")
-	ErrInvalidTrustedIPRange = errors.New("invalid trusted IP range")
-	ErrPanic                 = errors.New("panic")
-	ErrUntrustedIP           = errors.New("request from untrusted IP denied")
+	ErrGettingLocalIPs         = errors.New("error getting local IPs")
+	ErrGettingCloudflareIPs    = errors.New("error getting Cloudflare IPs")
+	ErrGettingEdgeOneIPs       = errors.New("error getting EdgeOne IPs")
+	ErrInvalidTrustedIPRange   = errors.New("invalid trusted IP range")
+	ErrInvalidUntrustedIPRange = errors.New("invalid untrusted IP range")
+	ErrPanic                   = errors.New("panic")
+	ErrUntrustedIP             = errors.New("request from untrusted IP denied")
+	ErrProvidersNotReady       = errors.New("trusted IP providers are still loading")
 )
 
+// ProviderConfig holds the settings of a remote IP provider.
+type ProviderConfig struct {
+	RetryInitialDelay   string   `json:"retryInitialDelay,omitempty"`
+	RetryMaxDelay       string   `json:"retryMaxDelay,omitempty"`
+	RetryBudget         string   `json:"retryBudget,omitempty"`
+	Format              string   `json:"format,omitempty"`
+	URLs                []string `json:"urls,omitempty"`
+	SignaturePublicKey  string   `json:"signaturePublicKey,omitempty"`
+	AllowedContentTypes []string `json:"allowedContentTypes,omitempty"`
+	MaxShrinkRatio      float64  `json:"maxShrinkRatio,omitempty"`
+	MaxBodySize         int64    `json:"maxBodySize,omitempty"`
+	MaxRetries          int      `json:"maxRetries,omitempty"`
+	MinCIDRs            int      `json:"minCIDRs,omitempty"`
+	MinPrefixLength     int      `json:"minPrefixLength,omitempty"`
+	Required            bool     `json:"required,omitempty"`
+}
+
 // Config the plugin configuration.
 type Config struct {
-	LogLevel         string   `json:"logLevel,omitempty"`
-	TrustedIPs       []string `json:"trustedIPs,omitempty"`
-	ThrustLocal      bool     `json:"thrustLocal,omitempty"`
-	ThrustCloudFlare bool     `json:"thrustCloudFlare,omitempty"`
-	ThrustEdgeOne    bool     `json:"thrustEdgeOne,omitempty"`
-	DenyUntrusted    bool     `json:"denyUntrusted,omitempty"`
+	CloudFlare           ProviderConfig     `json:"cloudFlare,omitempty"`
+	EdgeOne              ProviderConfig     `json:"edgeOne,omitempty"`
+	HTTPClient           HTTPClientConfig   `json:"httpClient,omitempty"`
+	Export               ExportConfig       `json:"export,omitempty"`
+	EmbeddedIPv4         EmbeddedIPv4Config `json:"embeddedIPv4,omitempty"`
+	DNS                  DNSConfig          `json:"dns,omitempty"`
+	Docker               DockerConfig       `json:"docker,omitempty"`
+	Kubernetes           KubernetesConfig   `json:"kubernetes,omitempty"`
+	LogLevel             string             `json:"logLevel,omitempty"`
+	NotReadyPolicy       string             `json:"notReadyPolicy,omitempty"`
+	PrivateClientPolicy  string             `json:"privateClientPolicy,omitempty"`
+	OnInvalidHeader      string             `json:"onInvalidHeader,omitempty"`
+	InvalidHeaderStatus  int                `json:"invalidHeaderStatus,omitempty"`
+	TrustedIPs           []string           `json:"trustedIPs,omitempty"`
+	UntrustedIPs         []string           `json:"untrustedIPs,omitempty"`
+	TrustedHosts         []string           `json:"trustedHosts,omitempty"`
+	NonPublicRanges      map[string]bool    `json:"nonPublicRanges,omitempty"`
+	TrustedIPsFile       string             `json:"trustedIPsFile,omitempty"`
+	FilePollInterval     string             `json:"filePollInterval,omitempty"`
+	TrustedIPsFileFormat string             `json:"trustedIPsFileFormat,omitempty"`
+	ThrustLocal          bool               `json:"thrustLocal,omitempty"`
+	ThrustCloudFlare     bool               `json:"thrustCloudFlare,omitempty"`
+	ThrustEdgeOne        bool               `json:"thrustEdgeOne,omitempty"`
+	DenyUntrusted        bool               `json:"denyUntrusted,omitempty"`
+	TrustCacheSize       int                `json:"trustCacheSize,omitempty"`
+	NonBlockingStartup   bool               `json:"nonBlockingStartup,omitempty"`
 }
 
 // CreateConfig creates the default plugin configuration.
 func CreateConfig() *Config {
 	return &Config{
-		ThrustLocal:      true,
-		ThrustCloudFlare: true,
-		ThrustEdgeOne:    false,
-		TrustedIPs:       make([]string, 0),
-		LogLevel:         "info",
-		DenyUntrusted:    false,
+		ThrustLocal:        true,
+		ThrustCloudFlare:   true,
+		ThrustEdgeOne:      false,
+		TrustedIPs:         make([]string, 0),
+		LogLevel:           "info",
+		DenyUntrusted:      false,
+		TrustCacheSize:     0,
+		NonBlockingStartup: false,
+		NotReadyPolicy:     NotReadyPolicyStatic,
+		OnInvalidHeader:    InvalidHeaderPolicyReject,
 	}
 }
 
 // IPResolver plugin.
 type IPResolver struct {
-	next          http.Handler
-	conf          *Config
-	logger        *PluginLogger
-	name          string
-	trustedIPNets []*net.IPNet
+	next               http.Handler
+	conf               *Config
+	logger             *PluginLogger
+	trustCache         *trustCache
+	nonPublic          *nonPublicTable
+	registry           *providerRegistry
+	httpClient         *providerHTTPClient
+	trustedIPProviders map[netip.Prefix]string
+	providerSettings   map[string]providerSettings
+	name               string
+	export             exportSettings
+	embeddedIPv4       embeddedIPv4Settings
+	dns                dnsSettings
+	docker             dockerSettings
+	kubernetes         kubernetesSettings
+	fileFormat         string
+	filePollInterval   time.Duration
+	notReady           string
+	privateClient      string
+	onInvalidHeader    string
+	invalidStatus      int
+	trustSources       map[string][]netip.Prefix
+	references         map[string]bool
+	trustedIPNets      []netip.Prefix
+	untrustedIPNets    []netip.Prefix
+	providerKeys       []string
+	trustedHosts       []*trustedHost
+	timedEntries       []*timedEntry
+	trustMu            sync.RWMutex
+	providerMu         sync.Mutex
+	timedMu            sync.Mutex
+	timedDeadline      atomic.Int64
+	providersPending   atomic.Bool
+	invalidHeaders     invalidHeaderCounters
+	stop               context.CancelFunc
+	released           bool
 }
 
 // New created a new IPResolver plugin.
@@ -63,53 +139,207 @@ func New(
 	name string,
 ) (http.Handler, error) {
 	ipResolver := &IPResolver{
-		next: next,
-		conf: config,
-		name: name,
+		next:       next,
+		conf:       config,
+		name:       name,
+		trustCache: newTrustCache(config.TrustCacheSize),
+		registry:   defaultProviderRegistry,
 	}
 
 	pluginLogger := NewPluginLogger(ctx, name, config.LogLevel)
 	ipResolver.logger = pluginLogger
 
-	trustedIPNets := make([]*net.IPNet, 0)
+	notReadyPolicy, err := parseNotReadyPolicy(config.NotReadyPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	ipResolver.notReady = notReadyPolicy
+
+	ipResolver.privateClient, err = parsePrivateClientPolicy(config.PrivateClientPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	ipResolver.onInvalidHeader, ipResolver.invalidStatus, err = parseInvalidHeaderPolicy(
+		config.OnInvalidHeader,
+		config.InvalidHeaderStatus,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ipResolver.nonPublic, err = parseNonPublicRanges(config.NonPublicRanges)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, ipRange := range config.TrustedIPs {
-		_, ipNet, err := net.ParseCIDR(ipRange)
+	ipResolver.httpClient, err = newProviderHTTPClient(config.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+
+	ipResolver.filePollInterval, err = parseFilePollInterval(config.FilePollInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	ipResolver.fileFormat, err = parseListFormat(config.TrustedIPsFileFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	ipResolver.export, err = parseExportConfig(config.Export)
+	if err != nil {
+		return nil, err
+	}
+
+	ipResolver.embeddedIPv4, err = parseEmbeddedIPv4Config(config.EmbeddedIPv4)
+	if err != nil {
+		return nil, err
+	}
+
+	cloudFlareSettings, err := parseProviderConfig("cloudFlare", config.CloudFlare)
+	if err != nil {
+		return nil, err
+	}
+
+	edgeOneSettings, err := parseProviderConfig("edgeOne", config.EdgeOne)
+	if err != nil {
+		return nil, err
+	}
+
+	ipResolver.providerSettings = map[string]providerSettings{
+		cloudflareProvider.name: cloudFlareSettings,
+		edgeOneProvider.name:    edgeOneSettings,
+	}
+
+	staticSources := make(map[string][]netip.Prefix)
+
+	parsed, err := parseTrustedIPs(config.TrustedIPs, config.UntrustedIPs)
+	if err != nil {
+		return nil, err
+	}
+
+	ipResolver.references = parsed.references
+	ipResolver.untrustedIPNets = parsed.excluded
+	staticSources["custom"] = parsed.trusted
+
+	ipResolver.dns, err = parseDNSConfig(config.DNS)
+	if err != nil {
+		return nil, err
+	}
+
+	ipResolver.trustedHosts, err = parseTrustedHosts(config.TrustedHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	ipResolver.docker, err = parseDockerConfig(config.Docker)
+	if err != nil {
+		return nil, err
+	}
+
+	ipResolver.kubernetes, err = parseKubernetesConfig(config.Kubernetes)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.TrustedIPsFile != "" {
+		ips, err := ipResolver.readTrustedIPsFile(ctx, config.TrustedIPsFile)
 		if err != nil {
-			return nil, fmt.Errorf("%w: %s", ErrInvalidTrustedIPRange, ipRange)
+			return nil, err
 		}
 
-		trustedIPNets = append(trustedIPNets, ipNet)
+		ipResolver.logTrustedIPFetchResult(ctx, "file", len(ips))
+		staticSources[fileSourceName] = ips
 	}
 
-	results := sync.Map{}
-	errWg, errCtx := errgroup.WithContext(ctx)
+	// Everything above only validates the configuration. From here on,
+	// watchers and pollers run until the instance is closed or ctx is done.
+	// Provider ranges are shared between instances; hand our references back
+	// at the same time.
+	ctx, ipResolver.stop = context.WithCancel(ctx)
+	context.AfterFunc(ctx, ipResolver.releaseProviders)
 
-	if config.ThrustLocal {
-		errWg.Go(func() error {
-			ips := ipResolver.getLocalIPs(errCtx)
-			ipResolver.logTrustedIPFetchResult(errCtx, "local", len(ips))
-			results.Store("local", ips)
+	if ipResolver.trustsSet(localProviderName, config.ThrustLocal) {
+		ips := ipResolver.getLocalIPs(ctx)
+		ipResolver.logTrustedIPFetchResult(ctx, "local", len(ips))
 
-			return nil
-		})
+		staticSources[localProviderName] = ips
 	}
 
-	if config.ThrustCloudFlare {
+	ipResolver.setTrustedSources(staticSources)
+	ipResolver.scheduleTimedEntries(ctx, parsed.timed)
+
+	if config.NonBlockingStartup {
+		ipResolver.loadRemoteProvidersInBackground(ctx)
+	} else {
+		ipResolver.watchDiscoveredSources(ctx)
+
+		err := ipResolver.loadRemoteProviders(ctx)
+		if err != nil {
+			ipResolver.stop()
+
+			return nil, err
+		}
+	}
+
+	ipResolver.watchFileSources(ctx)
+	ipResolver.exportTrustedRanges(ctx)
+
+	return ipResolver, nil
+}
+
+// Close stops the background watchers and pollers of the instance and
+// releases its provider references. Traefik never calls it: a configuration
+// reload neither closes the previous instance nor cancels its context, so
+// embedders that build the middleware themselves must close it when done.
+func (resolver *IPResolver) Close() error {
+	if resolver.stop != nil {
+		resolver.stop()
+	}
+
+	return nil
+}
+
+// watchDiscoveredSources resolves the trusted hosts, Docker networks and
+// Kubernetes ranges, and keeps them up to date until ctx is done.
+func (resolver *IPResolver) watchDiscoveredSources(ctx context.Context) {
+	resolver.watchTrustedHosts(ctx)
+	resolver.watchDockerNetworks(ctx)
+	resolver.watchKubernetes(ctx)
+}
+
+// loadRemoteProviders fetches the remote provider ranges and installs them
+// next to the static ranges.
+func (resolver *IPResolver) loadRemoteProviders(ctx context.Context) error {
+	results := sync.Map{}
+	errWg, errCtx := errgroup.WithContext(ctx)
+
+	if resolver.trustsSet(cloudflareSetRef, resolver.conf.ThrustCloudFlare) {
 		errWg.Go(func() error {
-			ips := ipResolver.getCloudFlareIPs(errCtx)
-			ipResolver.logTrustedIPFetchResult(errCtx, "Cloudflare", len(ips))
-			results.Store("cloudflare", ips)
+			ips := resolver.getCloudFlareIPs(errCtx)
+			resolver.logTrustedIPFetchResult(errCtx, "Cloudflare", len(ips))
+			results.Store(cloudflareSetRef, ips)
+
+			if len(ips) == 0 && resolver.conf.CloudFlare.Required {
+				return fmt.Errorf("%w: required provider returned no ranges", ErrGettingCloudflareIPs)
+			}
 
 			return nil
 		})
 	}
 
-	if config.ThrustEdgeOne {
+	if resolver.trustsSet(edgeOneSetRef, resolver.conf.ThrustEdgeOne) {
 		errWg.Go(func() error {
-			ips := ipResolver.getEdgeOneIPs(errCtx)
-			ipResolver.logTrustedIPFetchResult(errCtx, "EdgeOne", len(ips))
-			results.Store("edgeone", ips)
+			ips := resolver.getEdgeOneIPs(errCtx)
+			resolver.logTrustedIPFetchResult(errCtx, "EdgeOne", len(ips))
+			results.Store(edgeOneSetRef, ips)
+
+			if len(ips) == 0 && resolver.conf.EdgeOne.Required {
+				return fmt.Errorf("%w: required provider returned no ranges", ErrGettingEdgeOneIPs)
+			}
 
 			return nil
 		})
@@ -117,13 +347,15 @@ func New(
 
 	err := errWg.Wait()
 	if err != nil {
-		return nil, fmt.Errorf("error fetching trusted IPs: %w", err)
+		return fmt.Errorf("error fetching trusted IPs: %w", err)
 	}
 
+	sources := make(map[string][]netip.Prefix)
+
 	results.Range(func(key, value any) bool {
-		ips, ok := value.([]*net.IPNet)
+		ips, ok := value.([]netip.Prefix)
 		if !ok {
-			ipResolver.logger.WarnContext(
+			resolver.logger.WarnContext(
 				errCtx,
 				"Invalid type for trusted IPs",
 				slog.String("key", fmt.Sprintf("%v", key)),
@@ -132,14 +364,14 @@ func New(
 			return true
 		}
 
-		trustedIPNets = append(trustedIPNets, ips...)
+		sources[fmt.Sprintf("%v", key)] = ips
 
 		return true
 	})
 
-	ipResolver.trustedIPNets = trustedIPNets
+	resolver.setTrustedSources(sources)
 
-	return ipResolver, nil
+	return nil
 }
 
 func (resolver *IPResolver) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
@@ -157,23 +389,48 @@ func (resolver *IPResolver) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 
 	resolver.logger.DebugContext(ctx, "Source IP", slog.String("ip", srcIP.String()))
 
+	notReadyPolicy := resolver.notReadyPolicy()
+	if notReadyPolicy == NotReadyPolicyReject {
+		resolver.logger.WarnContext(
+			ctx,
+			"Rejecting request while trusted IP providers are loading",
+			slog.String("ip", srcIP.String()),
+		)
+		http.Error(rw, ErrProvidersNotReady.Error(), http.StatusServiceUnavailable)
+
+		return
+	}
+
+	pending := notReadyPolicy == NotReadyPolicyPending
+
 	ip, err := resolver.getRealIP(ctx, srcIP, req)
 	if err != nil {
 		resolver.logger.ErrorContext(ctx, "Error getting real IP", slog.Any("error", err))
-		http.Error(rw, err.Error(), http.StatusBadRequest)
+
+		status := http.StatusBadRequest
+
+		switch {
+		case errors.Is(err, ErrPrivateClientIP):
+			status = http.StatusForbidden
+		case isInvalidHeaderError(err):
+			status = resolver.invalidStatus
+		}
+
+		http.Error(rw, err.Error(), status)
 
 		return
 	}
 
-	isTrusted := resolver.isTrustedIP(ctx, srcIP)
+	isTrusted, provider := resolver.matchTrustedIP(ctx, srcIP)
 	resolver.logger.DebugContext(
 		ctx,
 		"IP is trusted",
 		slog.String("ip", srcIP.String()),
 		slog.Bool("isTrusted", isTrusted),
+		slog.String("provider", provider),
 	)
 
-	if !isTrusted && resolver.conf.DenyUntrusted {
+	if !isTrusted && !pending && resolver.conf.DenyUntrusted {
 		resolver.logger.WarnContext(
 			ctx,
 			"Denying request from untrusted IP",
@@ -184,12 +441,22 @@ func (resolver *IPResolver) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	if isTrusted {
+	switch {
+	case pending:
+		req.Header.Set(XIsTrusted, TrustedPending)
+	case isTrusted:
 		req.Header.Set(XIsTrusted, "yes")
-	} else {
+	default:
 		req.Header.Set(XIsTrusted, "no")
 	}
 
+	// The labels of the sources whose ranges matched, never a client value.
+	if isTrusted {
+		req.Header.Set(XTrustedSource, provider)
+	} else {
+		req.Header.Del(XTrustedSource)
+	}
+
 	req.Header.Set(XRealIP, ip.String())
 	resolver.logger.DebugContext(
 		ctx,
@@ -198,6 +465,8 @@ func (resolver *IPResolver) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 		slog.String("value", ip.String()),
 	)
 
+	resolver.setEmbeddedIPv4Header(ctx, req, ip)
+
 	if isTrusted {
 		resolver.handleTrustedIPNets(ctx, req, ip)
 	} else {
@@ -236,8 +505,13 @@ func (resolver *IPResolver) logTrustedIPFetchResult(
 	)
 }
 
-func (resolver *IPResolver) handleTrustedIPNets(ctx context.Context, req *http.Request, ip net.IP) {
-	if req.Header.Get(XForwardedFor) == "" {
+func (resolver *IPResolver) handleTrustedIPNets(
+	ctx context.Context,
+	req *http.Request,
+	ip netip.Addr,
+) {
+	tokens := tokenizeXForwardedFor(req.Header.Values(XForwardedFor))
+	if len(tokens) == 0 {
 		req.Header.Set(XForwardedFor, ip.String())
 		resolver.logger.DebugContext(
 			ctx,
@@ -249,21 +523,16 @@ func (resolver *IPResolver) handleTrustedIPNets(ctx context.Context, req *http.R
 		return
 	}
 
-	newVals := make([]string, 0)
+	newVals := make([]string, 0, len(tokens)+1)
 	newVals = append(newVals, ip.String())
 
-	//nolint:modernize // yaegi does not support strings.SplitSeq
-	vals := strings.Split(req.Header.Get(XForwardedFor), ",")
-	for _, val := range vals {
-		if strings.TrimSpace(val) == "" {
-			continue
-		}
-
-		if strings.TrimSpace(val) == ip.String() {
+	// Every header line is kept, the client IP is moved to the front.
+	for _, token := range tokens {
+		if token.err == nil && token.addr == ip {
 			continue
 		}
 
-		newVals = append(newVals, strings.TrimSpace(val))
+		newVals = append(newVals, token.value)
 	}
 
 	req.Header.Set(XForwardedFor, strings.Join(newVals, ", "))
@@ -1,41 +1,23 @@
 package traefik_real_ip
 
 import (
-	"net"
+	"errors"
 	"net/http"
 	"net/http/httptest"
-	"sync"
+	"net/netip"
 	"testing"
 )
 
-func TestNew_EmptyEdgeOneProviderDoesNotFail(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	}))
-	defer server.Close()
-
-	originalProvider := edgeOneProvider
-	originalInstance := edgeOneIPsInstance
-
-	var localOnce sync.Once
-
-	edgeOneIPsInstance = nil
-	edgeOneProvider = remoteIPProvider{
-		name:  originalProvider.name,
-		urls:  []string{server.URL},
-		once:  &localOnce,
-		cache: &edgeOneIPsInstance,
-	}
-
-	defer func() {
-		edgeOneProvider = originalProvider
-		edgeOneIPsInstance = originalInstance
-	}()
+// newConfiguredResolver creates a resolver through New from the default
+// configuration, without the local and Cloudflare ranges, as changed by
+// configure.
+func newConfiguredResolver(t *testing.T, configure func(*Config)) *IPResolver {
+	t.Helper()
 
 	cfg := CreateConfig()
 	cfg.ThrustLocal = false
 	cfg.ThrustCloudFlare = false
-	cfg.ThrustEdgeOne = true
+	configure(cfg)
 
 	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
 
@@ -49,6 +31,29 @@ func TestNew_EmptyEdgeOneProviderDoesNotFail(t *testing.T) {
 		t.Fatalf("expected *IPResolver, got %T", handler)
 	}
 
+	return resolver
+}
+
+func TestNew_EmptyEdgeOneProviderDoesNotFail(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	originalProvider := edgeOneProvider
+	edgeOneProvider = remoteIPProvider{
+		name: originalProvider.name,
+		urls: []string{server.URL},
+	}
+
+	defer func() {
+		edgeOneProvider = originalProvider
+	}()
+
+	resolver := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.ThrustEdgeOne = true
+	})
+
 	if len(resolver.trustedIPNets) != 0 {
 		t.Fatalf("expected no trusted IPs, got %d", len(resolver.trustedIPNets))
 	}
@@ -71,50 +76,160 @@ func TestNew_PartialCloudflareProviderDataDoesNotFail(t *testing.T) {
 	defer ipv6Server.Close()
 
 	originalProvider := cloudflareProvider
-	originalInstance := cloudFlareIPsInstance
+	cloudflareProvider = remoteIPProvider{
+		name: originalProvider.name,
+		urls: []string{ipv4Server.URL, ipv6Server.URL},
+	}
+
+	defer func() {
+		cloudflareProvider = originalProvider
+	}()
 
-	var localOnce sync.Once
+	resolver := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.ThrustCloudFlare = true
+		cfg.ThrustEdgeOne = false
+		cfg.CloudFlare.RetryInitialDelay = "1ms"
+		cfg.CloudFlare.RetryMaxDelay = "5ms"
+	})
 
-	cloudFlareIPsInstance = nil
-	cloudflareProvider = remoteIPProvider{
-		name:  originalProvider.name,
-		urls:  []string{ipv4Server.URL, ipv6Server.URL},
-		once:  &localOnce,
-		cache: &cloudFlareIPsInstance,
+	if len(resolver.trustedIPNets) != 1 {
+		t.Fatalf("expected one trusted IP, got %d", len(resolver.trustedIPNets))
 	}
 
+	expectedNet := netip.MustParsePrefix("173.245.48.0/20")
+
+	if resolver.trustedIPNets[0].String() != expectedNet.String() {
+		t.Fatalf("expected trusted IP %s, got %s", expectedNet, resolver.trustedIPNets[0])
+	}
+}
+
+func TestNew_RequiredProviderWithoutRanges(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	emptyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer emptyServer.Close()
+
+	originalCloudflare := cloudflareProvider
+	originalEdgeOne := edgeOneProvider
+	cloudflareProvider = remoteIPProvider{name: originalCloudflare.name, urls: []string{server.URL}}
+	edgeOneProvider = remoteIPProvider{name: originalEdgeOne.name, urls: []string{emptyServer.URL}}
+
+	defer func() {
+		cloudflareProvider = originalCloudflare
+		edgeOneProvider = originalEdgeOne
+	}()
+
+	tests := []struct {
+		expected   error
+		name       string
+		cloudflare bool
+		edgeOne    bool
+	}{
+		{name: "Cloudflare fetch fails", cloudflare: true, expected: ErrGettingCloudflareIPs},
+		{name: "EdgeOne list empty", edgeOne: true, expected: ErrGettingEdgeOneIPs},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.ThrustCloudFlare = tt.cloudflare
+			cfg.ThrustEdgeOne = tt.edgeOne
+			cfg.CloudFlare.Required = true
+			cfg.EdgeOne.Required = true
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+			_, err := New(t.Context(), next, cfg, "test")
+			if !errors.Is(err, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestNew_RequiredProviderWithRanges(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("173.245.48.0/20\n"))
+	}))
+	defer server.Close()
+
+	originalProvider := cloudflareProvider
+	cloudflareProvider = remoteIPProvider{name: originalProvider.name, urls: []string{server.URL}}
+
 	defer func() {
 		cloudflareProvider = originalProvider
-		cloudFlareIPsInstance = originalInstance
 	}()
 
 	cfg := CreateConfig()
 	cfg.ThrustLocal = false
-	cfg.ThrustCloudFlare = true
-	cfg.ThrustEdgeOne = false
+	cfg.CloudFlare.Required = true
 
 	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
 
-	handler, err := New(t.Context(), next, cfg, "test")
+	_, err := New(t.Context(), next, cfg, "test")
 	if err != nil {
 		t.Fatalf("New returned unexpected error: %v", err)
 	}
+}
 
-	resolver, ok := handler.(*IPResolver)
-	if !ok {
-		t.Fatalf("expected *IPResolver, got %T", handler)
+func TestNew_ExcludedRanges(t *testing.T) {
+	resolver := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.ThrustLocal = true
+		cfg.TrustedIPs = []string{"10.0.0.0/8", "!10.66.0.0/16", "10.66.1.0/24"}
+		cfg.UntrustedIPs = []string{"127.0.0.0/24", "10.66.1.128/25"}
+	})
+
+	tests := []struct {
+		ip      string
+		trusted bool
+	}{
+		{ip: "10.1.2.3", trusted: true},
+		{ip: "10.66.2.3", trusted: false},
+		{ip: "10.66.1.3", trusted: true},
+		{ip: "10.66.1.200", trusted: false},
+		{ip: "127.0.0.1", trusted: false},
+		{ip: "127.0.1.1", trusted: true},
 	}
 
-	if len(resolver.trustedIPNets) != 1 {
-		t.Fatalf("expected one trusted IP, got %d", len(resolver.trustedIPNets))
+	for _, tt := range tests {
+		got := resolver.isTrustedIP(t.Context(), netip.MustParseAddr(tt.ip))
+		if got != tt.trusted {
+			t.Errorf("isTrustedIP(%s) = %v, want %v", tt.ip, got, tt.trusted)
+		}
 	}
+}
 
-	_, expectedNet, err := net.ParseCIDR("173.245.48.0/20")
-	if err != nil {
-		t.Fatalf("ParseCIDR: %v", err)
+func TestNew_InvalidExcludedRanges(t *testing.T) {
+	tests := []struct {
+		name      string
+		trusted   []string
+		untrusted []string
+		wantErr   error
+	}{
+		{name: "negated", trusted: []string{"!10.0.0.0/33"}, wantErr: ErrInvalidTrustedIPRange},
+		{name: "untrusted", untrusted: []string{"nope"}, wantErr: ErrInvalidUntrustedIPRange},
 	}
 
-	if resolver.trustedIPNets[0].String() != expectedNet.String() {
-		t.Fatalf("expected trusted IP %s, got %s", expectedNet, resolver.trustedIPNets[0])
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := CreateConfig()
+			cfg.ThrustLocal = false
+			cfg.ThrustCloudFlare = false
+			cfg.TrustedIPs = tt.trusted
+			cfg.UntrustedIPs = tt.untrusted
+
+			next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+			_, err := New(t.Context(), next, cfg, "test")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
 	}
 }
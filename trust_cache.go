@@ -0,0 +1,127 @@
+package traefik_real_ip
+
+import (
+	"container/list"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+)
+
+// trustDecision is the memoised outcome of a trust evaluation.
+type trustDecision struct {
+	provider string
+	trusted  bool
+}
+
+type trustCacheEntry struct {
+	addr     netip.Addr
+	decision trustDecision
+}
+
+// trustCache is a bounded LRU of trust decisions keyed by source address.
+// A nil *trustCache is valid and behaves as a disabled cache.
+type trustCache struct {
+	entries  map[netip.Addr]*list.Element
+	order    *list.List
+	hits     atomic.Uint64
+	misses   atomic.Uint64
+	capacity int
+	mu       sync.Mutex
+}
+
+func newTrustCache(capacity int) *trustCache {
+	if capacity <= 0 {
+		return nil
+	}
+
+	return &trustCache{
+		entries:  make(map[netip.Addr]*list.Element, capacity),
+		order:    list.New(),
+		capacity: capacity,
+	}
+}
+
+func (cache *trustCache) get(addr netip.Addr) (trustDecision, bool) {
+	if cache == nil {
+		return trustDecision{}, false
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	elem, ok := cache.entries[addr]
+	if !ok {
+		cache.misses.Add(1)
+
+		return trustDecision{}, false
+	}
+
+	cache.hits.Add(1)
+	cache.order.MoveToFront(elem)
+
+	entry, _ := elem.Value.(*trustCacheEntry)
+
+	return entry.decision, true
+}
+
+func (cache *trustCache) add(addr netip.Addr, decision trustDecision) {
+	if cache == nil {
+		return
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if elem, ok := cache.entries[addr]; ok {
+		entry, _ := elem.Value.(*trustCacheEntry)
+		entry.decision = decision
+		cache.order.MoveToFront(elem)
+
+		return
+	}
+
+	cache.entries[addr] = cache.order.PushFront(&trustCacheEntry{addr: addr, decision: decision})
+
+	for cache.order.Len() > cache.capacity {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+
+		entry, _ := oldest.Value.(*trustCacheEntry)
+		delete(cache.entries, entry.addr)
+	}
+}
+
+// purge drops every cached decision. It must be called under the write lock
+// of the trust table whenever the table changes.
+func (cache *trustCache) purge() {
+	if cache == nil {
+		return
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.entries = make(map[netip.Addr]*list.Element, cache.capacity)
+	cache.order.Init()
+}
+
+func (cache *trustCache) len() int {
+	if cache == nil {
+		return 0
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	return cache.order.Len()
+}
+
+// stats returns the number of cache hits and misses since creation. They are
+// logged whenever the trust table changes.
+func (cache *trustCache) stats() (uint64, uint64) {
+	if cache == nil {
+		return 0, 0
+	}
+
+	return cache.hits.Load(), cache.misses.Load()
+}
@@ -0,0 +1,99 @@
+package traefik_real_ip
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestTrustCache_Disabled(t *testing.T) {
+	cache := newTrustCache(0)
+	if cache != nil {
+		t.Fatalf("expected nil cache for zero capacity, got %v", cache)
+	}
+
+	addr := netip.MustParseAddr("1.2.3.4")
+	cache.add(addr, trustDecision{trusted: true})
+
+	if _, ok := cache.get(addr); ok {
+		t.Error("disabled cache should never hit")
+	}
+
+	cache.purge()
+
+	if hits, misses := cache.stats(); hits != 0 || misses != 0 {
+		t.Errorf("expected zero stats, got hits=%d misses=%d", hits, misses)
+	}
+}
+
+func TestTrustCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newTrustCache(2)
+
+	first := netip.MustParseAddr("1.1.1.1")
+	second := netip.MustParseAddr("2.2.2.2")
+	third := netip.MustParseAddr("3.3.3.3")
+
+	cache.add(first, trustDecision{trusted: true, provider: "cloudflare"})
+	cache.add(second, trustDecision{})
+
+	// Touch first so second becomes the eviction candidate.
+	if _, ok := cache.get(first); !ok {
+		t.Fatal("expected hit for first")
+	}
+
+	cache.add(third, trustDecision{})
+
+	if _, ok := cache.get(second); ok {
+		t.Error("expected second to be evicted")
+	}
+
+	decision, ok := cache.get(first)
+	if !ok || !decision.trusted || decision.provider != "cloudflare" {
+		t.Errorf("unexpected decision for first: %+v (ok=%v)", decision, ok)
+	}
+
+	if cache.len() != 2 {
+		t.Errorf("expected 2 entries, got %d", cache.len())
+	}
+
+	hits, misses := cache.stats()
+	if hits != 2 || misses != 1 {
+		t.Errorf("expected hits=2 misses=1, got hits=%d misses=%d", hits, misses)
+	}
+}
+
+func TestIPResolver_matchTrustedIP_CacheInvalidation(t *testing.T) {
+	resolver := &IPResolver{
+		logger:     NewPluginLogger(t.Context(), "test", LogLevelDebug),
+		trustCache: newTrustCache(16),
+	}
+
+	resolver.setTrustedSources(map[string][]netip.Prefix{
+		"custom": {netip.MustParsePrefix("203.0.113.0/24")},
+	})
+
+	addr := netip.MustParseAddr("203.0.113.7")
+
+	trusted, provider := resolver.matchTrustedIP(t.Context(), addr)
+	if !trusted || provider != "custom" {
+		t.Fatalf("expected trusted by custom, got trusted=%v provider=%q", trusted, provider)
+	}
+
+	trusted, _ = resolver.matchTrustedIP(t.Context(), netip.MustParseAddr("::ffff:203.0.113.7"))
+	if !trusted {
+		t.Fatal("expected IPv4-mapped address to hit the cached decision")
+	}
+
+	if hits, _ := resolver.trustCache.stats(); hits != 1 {
+		t.Errorf("expected 1 cache hit, got %d", hits)
+	}
+
+	resolver.setTrustedSources(map[string][]netip.Prefix{"custom": nil})
+
+	if resolver.trustCache.len() != 0 {
+		t.Fatalf("expected cache to be purged, got %d entries", resolver.trustCache.len())
+	}
+
+	if resolver.isTrustedIP(t.Context(), addr) {
+		t.Error("expected address to be untrusted after the trust table changed")
+	}
+}
@@ -0,0 +1,293 @@
+package traefik_real_ip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidTrustedHost = errors.New("invalid trusted host")
+	ErrInvalidDNSConfig   = errors.New("invalid DNS configuration")
+)
+
+const (
+	trustedHostLabelPrefix       = "dns:"
+	defaultDNSTimeout            = 2 * time.Second
+	defaultDNSMinRefreshInterval = 5 * time.Second
+	defaultDNSRefreshInterval    = 5 * time.Minute
+)
+
+// DNSConfig configures how trustedHosts are resolved.
+type DNSConfig struct {
+	Resolver           string `json:"resolver,omitempty"`
+	Timeout            string `json:"timeout,omitempty"`
+	MinRefreshInterval string `json:"minRefreshInterval,omitempty"`
+	RefreshInterval    string `json:"refreshInterval,omitempty"`
+}
+
+// dnsSettings is the validated form of a DNSConfig. The TTL of an answer
+// is clamped between minRefresh and maxRefresh.
+type dnsSettings struct {
+	client     dnsClient
+	minRefresh time.Duration
+	maxRefresh time.Duration
+}
+
+// trustedHost is a trustedHosts entry with its last good answer.
+type trustedHost struct {
+	next  time.Time
+	name  string
+	addrs []netip.Prefix
+}
+
+func parseDNSConfig(config DNSConfig) (dnsSettings, error) {
+	settings := dnsSettings{
+		client:     dnsClient{timeout: defaultDNSTimeout},
+		minRefresh: defaultDNSMinRefreshInterval,
+		maxRefresh: defaultDNSRefreshInterval,
+	}
+
+	durations := []struct {
+		target *time.Duration
+		field  string
+		value  string
+	}{
+		{target: &settings.client.timeout, field: "timeout", value: config.Timeout},
+		{
+			target: &settings.minRefresh,
+			field:  "minRefreshInterval",
+			value:  config.MinRefreshInterval,
+		},
+		{
+			target: &settings.maxRefresh,
+			field:  "refreshInterval",
+			value:  config.RefreshInterval,
+		},
+	}
+
+	for _, duration := range durations {
+		if duration.value == "" {
+			continue
+		}
+
+		parsed, err := time.ParseDuration(duration.value)
+		if err != nil || parsed <= 0 {
+			return dnsSettings{}, fmt.Errorf(
+				"%w: %s: %q", ErrInvalidDNSConfig, duration.field, duration.value,
+			)
+		}
+
+		*duration.target = parsed
+	}
+
+	if settings.maxRefresh < settings.minRefresh {
+		settings.maxRefresh = settings.minRefresh
+	}
+
+	if config.Resolver != "" {
+		server, err := dnsServerAddress(config.Resolver)
+		if err != nil {
+			return dnsSettings{}, err
+		}
+
+		settings.client.server = server
+	}
+
+	return settings, nil
+}
+
+// parseTrustedHosts validates the trustedHosts entries. Addresses are
+// rejected since they belong in trustedIPs.
+func parseTrustedHosts(entries []string) ([]*trustedHost, error) {
+	hosts := make([]*trustedHost, 0, len(entries))
+	seen := make(map[string]bool, len(entries))
+
+	for _, entry := range entries {
+		name := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(entry)), ".")
+
+		if !validHostName(name) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTrustedHost, entry)
+		}
+
+		if seen[name] {
+			continue
+		}
+
+		seen[name] = true
+
+		hosts = append(hosts, &trustedHost{name: name})
+	}
+
+	return hosts, nil
+}
+
+func validHostName(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+
+	_, err := netip.ParseAddr(name)
+	if err == nil {
+		return false
+	}
+
+	//nolint:modernize // yaegi does not support strings.SplitSeq
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+
+		for _, char := range label {
+			isAlnum := (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9')
+			if !isAlnum && char != '-' && char != '_' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// watchTrustedHosts resolves the trustedHosts entries and keeps refreshing
+// each of them when its TTL runs out, until ctx is done.
+func (resolver *IPResolver) watchTrustedHosts(ctx context.Context) {
+	if len(resolver.trustedHosts) == 0 {
+		return
+	}
+
+	if resolver.dns.client.server == "" {
+		resolver.dns.client.server = systemDNSServer()
+	}
+
+	next := resolver.refreshTrustedHosts(ctx, time.Now())
+
+	go func() {
+		timer := time.NewTimer(time.Until(next))
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+				next = resolver.refreshTrustedHosts(ctx, time.Now())
+				timer.Reset(time.Until(next))
+			}
+		}
+	}()
+}
+
+// refreshTrustedHosts resolves the hosts that are due, installs the answers
+// that changed and returns when the next host is due.
+func (resolver *IPResolver) refreshTrustedHosts(ctx context.Context, now time.Time) time.Time {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		updates = make(map[string][]netip.Prefix)
+	)
+
+	for _, host := range resolver.trustedHosts {
+		if now.Before(host.next) {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(host *trustedHost) {
+			defer wg.Done()
+
+			if resolver.resolveTrustedHost(ctx, host, now) {
+				mu.Lock()
+				updates[trustedHostLabelPrefix+host.name] = host.addrs
+				mu.Unlock()
+			}
+		}(host)
+	}
+
+	wg.Wait()
+
+	if len(updates) > 0 {
+		resolver.setTrustedSources(updates)
+	}
+
+	next := resolver.trustedHosts[0].next
+	for _, host := range resolver.trustedHosts[1:] {
+		if host.next.Before(next) {
+			next = host.next
+		}
+	}
+
+	return next
+}
+
+// resolveTrustedHost looks host up and reports whether its addresses
+// changed. On failure the last good answer is kept and the lookup is retried
+// after the minimum refresh interval.
+func (resolver *IPResolver) resolveTrustedHost(
+	ctx context.Context,
+	host *trustedHost,
+	now time.Time,
+) bool {
+	answer, err := resolver.dns.client.lookup(ctx, host.name)
+	if err != nil {
+		resolver.logger.ErrorContext(
+			ctx,
+			"Error resolving trusted host, keeping the last good answer",
+			slog.String("host", host.name),
+			slog.Int("count", len(host.addrs)),
+			slog.Any("error", err),
+		)
+
+		host.next = now.Add(resolver.dns.minRefresh)
+
+		return false
+	}
+
+	host.next = now.Add(min(max(answer.ttl, resolver.dns.minRefresh), resolver.dns.maxRefresh))
+
+	addrs := make([]netip.Prefix, 0, len(answer.addrs))
+	for _, addr := range answer.addrs {
+		addrs = append(addrs, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i].Addr().Less(addrs[j].Addr())
+	})
+
+	if equalPrefixes(addrs, host.addrs) {
+		return false
+	}
+
+	host.addrs = addrs
+
+	resolver.logger.InfoContext(
+		ctx,
+		"Resolved trusted host",
+		slog.String("host", host.name),
+		slog.Int("count", len(addrs)),
+		slog.Duration("ttl", answer.ttl),
+	)
+
+	return true
+}
+
+func equalPrefixes(a, b []netip.Prefix) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,159 @@
+package traefik_real_ip
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestParseTrustedHosts(t *testing.T) {
+	hosts, err := parseTrustedHosts([]string{" LB.example.com. ", "lb.example.com", "edge_1.internal"})
+	if err != nil {
+		t.Fatalf("parseTrustedHosts: %v", err)
+	}
+
+	if len(hosts) != 2 || hosts[0].name != "lb.example.com" || hosts[1].name != "edge_1.internal" {
+		t.Errorf("unexpected hosts %+v", hosts)
+	}
+
+	entries := []string{
+		"", "192.0.2.1", "::1", "lb..example.com", "lb example.com", "*.example.com",
+	}
+
+	for _, entry := range entries {
+		_, err := parseTrustedHosts([]string{entry})
+		if !errors.Is(err, ErrInvalidTrustedHost) {
+			t.Errorf("%q: expected ErrInvalidTrustedHost, got %v", entry, err)
+		}
+	}
+}
+
+func TestParseDNSConfig(t *testing.T) {
+	settings, err := parseDNSConfig(DNSConfig{})
+	if err != nil {
+		t.Fatalf("parseDNSConfig: %v", err)
+	}
+
+	if settings.client.server != "" || settings.client.timeout != defaultDNSTimeout ||
+		settings.minRefresh != defaultDNSMinRefreshInterval ||
+		settings.maxRefresh != defaultDNSRefreshInterval {
+		t.Errorf("unexpected defaults %+v", settings)
+	}
+
+	settings, err = parseDNSConfig(DNSConfig{
+		Resolver:           "10.0.0.2",
+		MinRefreshInterval: "1m",
+		RefreshInterval:    "30s",
+	})
+	if err != nil {
+		t.Fatalf("parseDNSConfig: %v", err)
+	}
+
+	if settings.client.server != "10.0.0.2:53" || settings.maxRefresh != time.Minute {
+		t.Errorf("unexpected settings %+v", settings)
+	}
+
+	for _, config := range []DNSConfig{
+		{Resolver: "dns.example.com"},
+		{Timeout: "soon"},
+		{MinRefreshInterval: "0s"},
+		{RefreshInterval: "-1m"},
+	} {
+		_, err := parseDNSConfig(config)
+		if !errors.Is(err, ErrInvalidDNSConfig) {
+			t.Errorf("%+v: expected ErrInvalidDNSConfig, got %v", config, err)
+		}
+	}
+}
+
+func TestNew_TrustedHosts(t *testing.T) {
+	server := newFakeDNSServer(t)
+	server.set(0, 0, "192.0.2.10", "2001:db8::10")
+
+	resolver := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.TrustedHosts = []string{"lb.example.com"}
+		cfg.DNS = DNSConfig{Resolver: server.addr, MinRefreshInterval: "5ms"}
+	})
+
+	ctx := t.Context()
+	first := netip.MustParseAddr("192.0.2.10")
+	second := netip.MustParseAddr("192.0.2.20")
+
+	trusted, provider := resolver.matchTrustedIP(ctx, first)
+	if !trusted || provider != "dns:lb.example.com" {
+		t.Errorf("expected the resolved address to be trusted, got %v %q", trusted, provider)
+	}
+
+	if !resolver.isTrustedIP(ctx, netip.MustParseAddr("2001:db8::10")) {
+		t.Error("expected the AAAA record to be trusted")
+	}
+
+	// The zero TTL is raised to the minimum refresh interval.
+	server.set(0, 0, "192.0.2.20")
+
+	waitFor(t, func() bool {
+		return resolver.isTrustedIP(ctx, second) && !resolver.isTrustedIP(ctx, first)
+	})
+
+	// A failing resolver keeps the last good answer.
+	server.set(0, 2)
+
+	queries := server.queries.Load()
+	waitFor(t, func() bool { return server.queries.Load() > queries+2 })
+
+	if !resolver.isTrustedIP(ctx, second) {
+		t.Error("expected the last good answer to be kept")
+	}
+}
+
+func TestNew_NonBlockingStartupResolvesTrustedHostsInBackground(t *testing.T) {
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	start := time.Now()
+
+	resolver := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.NonBlockingStartup = true
+		cfg.TrustedHosts = []string{"lb.example.com"}
+		cfg.DNS = DNSConfig{Resolver: silent.LocalAddr().String(), Timeout: "500ms"}
+	})
+	t.Cleanup(func() { _ = resolver.Close() })
+
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Errorf("expected New not to wait for the DNS timeout, took %s", elapsed)
+	}
+
+	if resolver.notReadyPolicy() == "" {
+		t.Error("expected the instance to be pending while the hosts resolve")
+	}
+
+	waitFor(t, func() bool { return resolver.notReadyPolicy() == "" })
+}
+
+func TestNew_InvalidTrustedHosts(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+
+	cfg := CreateConfig()
+	cfg.ThrustLocal = false
+	cfg.ThrustCloudFlare = false
+	cfg.TrustedHosts = []string{"10.0.0.1"}
+
+	_, err := New(t.Context(), next, cfg, "test")
+	if !errors.Is(err, ErrInvalidTrustedHost) {
+		t.Errorf("expected ErrInvalidTrustedHost, got %v", err)
+	}
+
+	cfg.TrustedHosts = []string{"lb.example.com"}
+	cfg.DNS.Resolver = "dns.example.com"
+
+	_, err = New(t.Context(), next, cfg, "test")
+	if !errors.Is(err, ErrInvalidDNSConfig) {
+		t.Errorf("expected ErrInvalidDNSConfig, got %v", err)
+	}
+}
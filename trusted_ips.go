@@ -0,0 +1,102 @@
+package traefik_real_ip
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Built-in sets that trustedIPs entries can reference as @name.
+const (
+	referencePrefix  = "@"
+	cloudflareSetRef = "cloudflare"
+	edgeOneSetRef    = "edgeone"
+)
+
+// trustedIPs is the parsed form of the trustedIPs and untrustedIPs options.
+type trustedIPs struct {
+	references map[string]bool
+	trusted    []netip.Prefix
+	excluded   []netip.Prefix
+	timed      []*timedEntry
+}
+
+// parseTrustedIPs reads trustedIPs entries: CIDRs, bare addresses, inclusive
+// start-end ranges, @cloudflare, @edgeone and @local references to the
+// built-in sets, and exclusions prefixed with !. Addresses may be followed by
+// notBefore and notAfter attributes limiting when they are trusted. Entries
+// of untrustedIPs use the same address syntax.
+func parseTrustedIPs(trustedEntries, untrustedEntries []string) (trustedIPs, error) {
+	result := trustedIPs{references: make(map[string]bool)}
+
+	for _, entry := range trustedEntries {
+		value := strings.TrimSpace(entry)
+
+		if name, ok := strings.CutPrefix(value, referencePrefix); ok {
+			name = strings.ToLower(name)
+			if name != localProviderName && name != cloudflareSetRef && name != edgeOneSetRef {
+				return trustedIPs{}, fmt.Errorf("%w: unknown set %s", ErrInvalidTrustedIPRange, entry)
+			}
+
+			result.references[name] = true
+
+			continue
+		}
+
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return trustedIPs{}, fmt.Errorf("%w: empty entry", ErrInvalidTrustedIPRange)
+		}
+
+		// A leading ! excludes the range, like an untrustedIPs entry.
+		excluded, negated := strings.CutPrefix(fields[0], "!")
+
+		prefixes, err := parseListEntry(excluded)
+		if err != nil {
+			return trustedIPs{}, fmt.Errorf("%w: %s", ErrInvalidTrustedIPRange, entry)
+		}
+
+		if len(fields) > 1 {
+			if negated {
+				return trustedIPs{}, fmt.Errorf(
+					"%w: %s: exclusions cannot be time-limited", ErrInvalidTrustedIPRange, entry,
+				)
+			}
+
+			timed, err := parseTimedAttributes(value, fields[1:])
+			if err != nil {
+				return trustedIPs{}, err
+			}
+
+			timed.prefixes = prefixes
+			result.timed = append(result.timed, timed)
+
+			continue
+		}
+
+		if negated {
+			result.excluded = append(result.excluded, prefixes...)
+
+			continue
+		}
+
+		result.trusted = append(result.trusted, prefixes...)
+	}
+
+	for _, entry := range untrustedEntries {
+		prefixes, err := parseListEntry(strings.TrimSpace(entry))
+		if err != nil {
+			return trustedIPs{}, fmt.Errorf("%w: %s", ErrInvalidUntrustedIPRange, entry)
+		}
+
+		result.excluded = append(result.excluded, prefixes...)
+	}
+
+	return result, nil
+}
+
+// trustsSet reports whether a built-in set is trusted, through its thrust
+// option or an @name reference in trustedIPs.
+func (resolver *IPResolver) trustsSet(name string, enabled bool) bool {
+	return enabled || resolver.references[name]
+}
@@ -0,0 +1,104 @@
+package traefik_real_ip
+
+import (
+	"errors"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseTrustedIPs(t *testing.T) {
+	parsed, err := parseTrustedIPs(
+		[]string{
+			"10.0.0.0/8",
+			"192.0.2.7",
+			" 2001:db8::1 ",
+			"10.0.0.1-10.0.0.6",
+			"!10.66.0.0/16",
+			"!198.51.100.1",
+			"@Cloudflare",
+			"@local",
+		},
+		[]string{"203.0.113.0-203.0.113.127", "172.16.0.1"},
+	)
+	if err != nil {
+		t.Fatalf("parseTrustedIPs: %v", err)
+	}
+
+	wantTrusted := []string{
+		"10.0.0.0/8", "192.0.2.7/32", "2001:db8::1/128",
+		"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31", "10.0.0.6/32",
+	}
+	if got := prefixStrings(parsed.trusted); !slices.Equal(got, wantTrusted) {
+		t.Errorf("trusted = %v, want %v", got, wantTrusted)
+	}
+
+	wantExcluded := []string{"10.66.0.0/16", "198.51.100.1/32", "203.0.113.0/25", "172.16.0.1/32"}
+	if got := prefixStrings(parsed.excluded); !slices.Equal(got, wantExcluded) {
+		t.Errorf("excluded = %v, want %v", got, wantExcluded)
+	}
+
+	if !parsed.references[cloudflareSetRef] || !parsed.references[localProviderName] ||
+		parsed.references[edgeOneSetRef] {
+		t.Errorf("unexpected references %v", parsed.references)
+	}
+}
+
+func TestParseTrustedIPs_Errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		trusted   []string
+		untrusted []string
+		wantErr   error
+	}{
+		{name: "unknown set", trusted: []string{"@akamai"}, wantErr: ErrInvalidTrustedIPRange},
+		{name: "negated set", trusted: []string{"!@cloudflare"}, wantErr: ErrInvalidTrustedIPRange},
+		{name: "hostname", trusted: []string{"proxy.local"}, wantErr: ErrInvalidTrustedIPRange},
+		{
+			name:    "reversed range",
+			trusted: []string{"10.0.0.50-10.0.0.1"},
+			wantErr: ErrInvalidTrustedIPRange,
+		},
+		{name: "untrusted set", untrusted: []string{"@local"}, wantErr: ErrInvalidUntrustedIPRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTrustedIPs(tt.trusted, tt.untrusted)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNew_SetReferences(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cloudflare.txt")
+	writeSourceFile(t, path, "173.245.48.0/20\n")
+
+	resolver := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.CloudFlare.URLs = []string{"file://" + path}
+		cfg.TrustedIPs = []string{"@cloudflare", "@local", "192.0.2.10-192.0.2.11"}
+	})
+
+	tests := []struct {
+		ip       string
+		provider string
+	}{
+		{ip: "173.245.48.1", provider: cloudflareSetRef},
+		{ip: "127.0.0.1", provider: localProviderName},
+		{ip: "192.0.2.11", provider: "custom"},
+	}
+
+	for _, tt := range tests {
+		trusted, provider := resolver.matchTrustedIP(t.Context(), netip.MustParseAddr(tt.ip))
+		if !trusted || provider != tt.provider {
+			t.Errorf("matchTrustedIP(%s) = %v, %q, want %q", tt.ip, trusted, provider, tt.provider)
+		}
+	}
+
+	if resolver.isTrustedIP(t.Context(), netip.MustParseAddr("192.0.2.12")) {
+		t.Error("expected the address after the range to be untrusted")
+	}
+}
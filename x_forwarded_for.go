@@ -0,0 +1,107 @@
+package traefik_real_ip
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// xForwardedForToken is an entry of X-Forwarded-For. err is set when the
+// entry is not an address; index counts the entries across header lines.
+type xForwardedForToken struct {
+	err   error
+	value string
+	addr  netip.Addr
+	index int
+}
+
+// tokenizeXForwardedFor splits the X-Forwarded-For header lines into their
+// entries. Several lines are treated as one comma-separated list as per RFC
+// 7230 section 3.2.2 and empty list elements are dropped.
+func tokenizeXForwardedFor(lines []string) []xForwardedForToken {
+	tokens := make([]xForwardedForToken, 0, len(lines))
+
+	for _, line := range lines {
+		//nolint:modernize // yaegi does not support strings.SplitSeq
+		for _, value := range strings.Split(line, ",") {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+
+			addr, err := parseXForwardedForEntry(value)
+			tokens = append(tokens, xForwardedForToken{
+				err:   err,
+				value: value,
+				addr:  addr,
+				index: len(tokens),
+			})
+		}
+	}
+
+	return tokens
+}
+
+// parseXForwardedForEntry parses an address optionally followed by a port, with
+// IPv6 addresses in brackets when a port is given. Zones are dropped since they
+// are only meaningful on the host that added the entry.
+func parseXForwardedForEntry(value string) (netip.Addr, error) {
+	host, port := value, ""
+
+	switch {
+	case strings.HasPrefix(value, "["):
+		end := strings.Index(value, "]")
+		if end < 0 {
+			return netip.Addr{}, fmt.Errorf("%w: missing closing bracket: %s", ErrInvalidIPFormat, value)
+		}
+
+		host = value[1:end]
+
+		rest := value[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return netip.Addr{}, fmt.Errorf(
+					"%w: unexpected text after bracket: %s", ErrInvalidIPFormat, value,
+				)
+			}
+
+			port = rest[1:]
+			if port == "" {
+				return netip.Addr{}, fmt.Errorf("%w: invalid port: %s", ErrInvalidIPFormat, value)
+			}
+		}
+	case strings.Count(value, ":") == 1:
+		host, port, _ = strings.Cut(value, ":")
+		if port == "" {
+			return netip.Addr{}, fmt.Errorf("%w: invalid port: %s", ErrInvalidIPFormat, value)
+		}
+	}
+
+	if port != "" && !validPort(port) {
+		return netip.Addr{}, fmt.Errorf("%w: invalid port: %s", ErrInvalidIPFormat, value)
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%w: %s", ErrInvalidIPFormat, value)
+	}
+
+	return addr.WithZone("").Unmap(), nil
+}
+
+func validPort(port string) bool {
+	if len(port) > 5 {
+		return false
+	}
+
+	for _, char := range port {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+
+	number, err := strconv.Atoi(port)
+
+	return err == nil && number <= 65535
+}
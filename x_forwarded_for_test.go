@@ -0,0 +1,105 @@
+package traefik_real_ip
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestParseXForwardedForEntry(t *testing.T) {
+	valid := map[string]string{
+		"9.9.9.9":             "9.9.9.9",
+		"9.9.9.9:4711":        "9.9.9.9",
+		"2001:db8::1":         "2001:db8::1",
+		"[2001:db8::1]":       "2001:db8::1",
+		"[2001:db8::1]:443":   "2001:db8::1",
+		"fe80::1%eth0":        "fe80::1",
+		"[fe80::1%eth0]:8080": "fe80::1",
+		"::ffff:9.9.9.9":      "9.9.9.9",
+	}
+
+	for value, expected := range valid {
+		addr, err := parseXForwardedForEntry(value)
+		if err != nil || addr != netip.MustParseAddr(expected) {
+			t.Errorf("%s: expected %s, got %v, %v", value, expected, addr, err)
+		}
+	}
+
+	for _, value := range []string{
+		"unknown",
+		"9.9.9.9:",
+		"9.9.9.9:http",
+		"9.9.9.9:65536",
+		"[2001:db8::1",
+		"[2001:db8::1]443",
+		"[2001:db8::1]:",
+		"_hidden",
+	} {
+		_, err := parseXForwardedForEntry(value)
+		if !errors.Is(err, ErrInvalidIPFormat) {
+			t.Errorf("%s: expected ErrInvalidIPFormat, got %v", value, err)
+		}
+	}
+}
+
+func TestTokenizeXForwardedFor(t *testing.T) {
+	tokens := tokenizeXForwardedFor([]string{"9.9.9.9:4711, ,bogus", "[2001:db8::1]:443"})
+
+	if len(tokens) != 3 {
+		t.Fatalf("expected 3 tokens, got %+v", tokens)
+	}
+
+	if tokens[0].addr != netip.MustParseAddr("9.9.9.9") || tokens[0].err != nil {
+		t.Errorf("unexpected first token %+v", tokens[0])
+	}
+
+	if tokens[1].index != 1 || tokens[1].value != "bogus" || tokens[1].err == nil {
+		t.Errorf("expected the malformed token to be reported, got %+v", tokens[1])
+	}
+
+	if tokens[2].index != 2 || tokens[2].addr != netip.MustParseAddr("2001:db8::1") {
+		t.Errorf("expected the second line to continue the list, got %+v", tokens[2])
+	}
+}
+
+func TestIPResolver_handleXForwardedFor_Lines(t *testing.T) {
+	resolver := &IPResolver{
+		logger: NewPluginLogger(t.Context(), "test", LogLevelDebug),
+	}
+
+	req, _ := http.NewRequestWithContext(t.Context(), http.MethodGet, "/", http.NoBody)
+	req.Header.Add(XForwardedFor, "192.168.1.1:1234")
+	req.Header.Add(XForwardedFor, "[2606:4700::1]:443, 9.9.9.9")
+
+	ip, err := resolver.handleXForwardedFor(t.Context(), req, netip.Addr{})
+	if err != nil || ip != netip.MustParseAddr("2606:4700::1") {
+		t.Errorf("expected 2606:4700::1, got %v, %v", ip, err)
+	}
+
+	req.Header.Set(XForwardedFor, "bogus, 9.9.9.9:http")
+
+	_, err = resolver.handleXForwardedFor(t.Context(), req, netip.Addr{})
+	if !errors.Is(err, ErrXForwardedForInvalid) {
+		t.Errorf("expected ErrXForwardedForInvalid, got %v", err)
+	}
+}
+
+func TestIPResolver_ServeHTTP_ForwardsEveryXForwardedForLine(t *testing.T) {
+	resolver := newConfiguredResolver(t, func(cfg *Config) {
+		cfg.TrustedIPs = []string{"10.0.0.0/8"}
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Add(XForwardedFor, "9.9.9.9")
+	req.Header.Add(XForwardedFor, "8.8.8.8:443, 10.2.2.2")
+
+	resolver.ServeHTTP(httptest.NewRecorder(), req)
+
+	forwarded := req.Header.Values(XForwardedFor)
+	if len(forwarded) != 1 || forwarded[0] != "9.9.9.9, 8.8.8.8:443, 10.2.2.2" {
+		t.Errorf("expected every entry to be forwarded, got %q", forwarded)
+	}
+}